@@ -1,12 +1,24 @@
 package config
 
-import "strconv"
+import (
+	"log/slog"
+	"strconv"
+	"time"
+)
 
 type Config struct {
-	DB     DBConfig
-	SMTP   SMTPConfig
-	CSRF   CSRFConfig
-	Server ServerConfig
+	DB      DBConfig
+	SMTP    SMTPConfig
+	CSRF    CSRFConfig
+	Flash   FlashConfig
+	Events  EventsConfig
+	Health  HealthConfig
+	Tracing TracingConfig
+	Log     LogConfig
+	Server  ServerConfig
+
+	// settings are the loaded values, see Config.Print.
+	settings []setting
 }
 
 type DBConfig struct {
@@ -16,6 +28,9 @@ type DBConfig struct {
 	Password string
 	Database string
 	SSLMode  string
+	// AutoMigrate applies pending migrations when the server starts. Turn it off to run
+	// them separately with imago migrate up, before the new version is deployed.
+	AutoMigrate bool
 }
 
 // GetDSN returns the PostgreSQL DSN (Data Source Name) for connecting to the database.
@@ -34,18 +49,87 @@ type SMTPConfig struct {
 	Username string
 	Password string
 	SSLMode  bool
+	// Transport selects how emails are delivered, one of the SMTPTransport values.
+	Transport SMTPTransport
+	// FileDir is where the file transport writes .eml files.
+	FileDir string
 }
 
+type SMTPTransport string
+
+const (
+	SMTPTransportSMTP   SMTPTransport = "smtp"
+	SMTPTransportFile   SMTPTransport = "file"
+	SMTPTransportLog    SMTPTransport = "log"
+	SMTPTransportMemory SMTPTransport = "memory"
+)
+
 type CSRFConfig struct {
 	Key    string
 	Secure bool
 }
 
+// FlashConfig holds the key used to sign flash notification cookies.
+type FlashConfig struct {
+	Key    string
+	Secure bool
+}
+
+type EventsConfig struct {
+	// Backend selects how live events reach subscribers, one of the EventBackend values.
+	Backend EventBackend
+}
+
+type EventBackend string
+
+const (
+	// EventBackendMemory delivers events within a single server instance.
+	EventBackendMemory EventBackend = "memory"
+	// EventBackendPostgres shares events between instances through LISTEN/NOTIFY.
+	EventBackendPostgres EventBackend = "postgres"
+)
+
+type TracingConfig struct {
+	// Exporter selects where spans are sent, one of the TracingExporter values.
+	Exporter TracingExporter
+	// Endpoint is the host:port of the OTLP/HTTP collector.
+	Endpoint string
+	// Insecure sends spans to the collector over plain HTTP.
+	Insecure bool
+	// SampleRatio is the fraction of new traces that are recorded, from 0 to 1.
+	SampleRatio float64
+}
+
+type TracingExporter string
+
+const (
+	TracingExporterNone   TracingExporter = "none"
+	TracingExporterStdout TracingExporter = "stdout"
+	TracingExporterOTLP   TracingExporter = "otlp"
+)
+
+type LogConfig struct {
+	// Level is the minimum level of logged lines, one of debug, info, warn or error.
+	Level slog.Level
+}
+
+type HealthConfig struct {
+	// Token protects the readiness report and the metrics. They are open to everyone
+	// when it is empty, which Validate only allows outside Prod.
+	Token string
+}
+
 type ServerConfig struct {
 	Host    string
 	Port    int
 	Env     Environment
 	SSLMode bool
+	// ShutdownTimeout is how long in-flight requests may take to finish after a
+	// shutdown signal before their connections are closed.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long the server keeps accepting requests while reporting not
+	// ready, so load balancers can take it out of rotation before it stops listening.
+	DrainDelay time.Duration
 }
 
 // GetAddr returns the address for the server, including protocol based on SSL mode.
@@ -61,36 +145,68 @@ func (c *ServerConfig) GetURL() string {
 	return "http://" + addr
 }
 
-func NewEnvConfig() *Config {
+// load reads every setting from the sources of l.
+func (l *loader) load() *Config {
 	const dbPort = 5432
 	const smtpPort = 587
 	const serverPort = 8080
+	const shutdownTimeout = 30 * time.Second
 
-	return &Config{
+	cnf := &Config{
 		DB: DBConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getIntEnv("DB_PORT", dbPort),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "postgres"),
-			Database: getEnv("DB_NAME", "postgres"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Host:     l.string("DB_HOST", "localhost"),
+			Port:     l.int("DB_PORT", dbPort),
+			User:     l.string("DB_USER", "postgres"),
+			Password: l.secret("DB_PASSWORD", defaultDBPassword),
+			Database: l.string("DB_NAME", "postgres"),
+			SSLMode:  l.string("DB_SSLMODE", "disable"),
+
+			AutoMigrate: l.bool("DB_AUTO_MIGRATE", true),
 		},
 		SMTP: SMTPConfig{
-			Host:     getEnv("SMTP_HOST", "localhost"),
-			Port:     getIntEnv("SMTP_PORT", smtpPort),
-			Username: getEnv("SMTP_USERNAME", "user"),
-			Password: getEnv("SMTP_PASSWORD", "password"),
-			SSLMode:  getBoolEnv("SMTP_SSLMODE", false),
+			Host:     l.string("SMTP_HOST", "localhost"),
+			Port:     l.int("SMTP_PORT", smtpPort),
+			Username: l.string("SMTP_USERNAME", "user"),
+			Password: l.secret("SMTP_PASSWORD", "password"),
+			SSLMode:  l.bool("SMTP_SSLMODE", false),
+
+			Transport: SMTPTransport(l.string("SMTP_TRANSPORT", string(SMTPTransportSMTP))),
+			FileDir:   l.string("SMTP_FILE_DIR", "mail"),
 		},
 		CSRF: CSRFConfig{
-			Key:    getEnv("CSRF_KEY", "default-key"),
-			Secure: getBoolEnv("CSRF_SECURE", false),
+			Key:    l.secret("CSRF_KEY", defaultCSRFKey),
+			Secure: l.bool("CSRF_SECURE", false),
+		},
+		Flash: FlashConfig{
+			Key:    l.secret("FLASH_KEY", defaultFlashKey),
+			Secure: l.bool("FLASH_SECURE", false),
+		},
+		Events: EventsConfig{
+			Backend: EventBackend(l.string("EVENTS_BACKEND", string(EventBackendMemory))),
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporter(l.string("TRACING_EXPORTER", string(TracingExporterNone))),
+			Endpoint:    l.string("TRACING_OTLP_ENDPOINT", "localhost:4318"),
+			Insecure:    l.bool("TRACING_OTLP_INSECURE", true),
+			SampleRatio: l.float("TRACING_SAMPLE_RATIO", 1),
+		},
+		Log: LogConfig{
+			Level: l.level("LOG_LEVEL", slog.LevelInfo),
+		},
+		Health: HealthConfig{
+			Token: l.secret("HEALTH_TOKEN", ""),
 		},
 		Server: ServerConfig{
-			Host:    getEnv("SERVER_HOST", "localhost"),
-			Port:    getIntEnv("SERVER_PORT", serverPort),
-			Env:     GetEnvironment("SERVER_ENV", Dev),
-			SSLMode: getBoolEnv("SERVER_SSLMODE", false),
+			Host:    l.string("SERVER_HOST", "localhost"),
+			Port:    l.int("SERVER_PORT", serverPort),
+			Env:     l.environment("SERVER_ENV", Dev),
+			SSLMode: l.bool("SERVER_SSLMODE", false),
+
+			ShutdownTimeout: l.duration("SERVER_SHUTDOWN_TIMEOUT", shutdownTimeout),
+			DrainDelay:      l.duration("SERVER_DRAIN_DELAY", 0),
 		},
 	}
+	cnf.settings = l.settings
+
+	return cnf
 }
@@ -0,0 +1,191 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azdanov/imago/config"
+)
+
+func load(t *testing.T, args ...string) (*config.Config, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("imago", flag.ContinueOnError)
+	return config.Load(fs, args)
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeFile(t, "imago.toml", `
+[db]
+host = "file-host"
+port = 5433
+user = "file-user"
+auto_migrate = false
+
+[server]
+port = 4000
+shutdown_timeout = "10s"
+`)
+	t.Setenv("DB_PORT", "5434")
+	t.Setenv("DB_USER", "env-user")
+
+	cnf, err := load(t, "-config", path, "-db-user", "flag-user")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cnf.DB.Host != "file-host" {
+		t.Errorf("DB.Host = %q, want the file value", cnf.DB.Host)
+	}
+	if cnf.DB.Port != 5434 {
+		t.Errorf("DB.Port = %d, want the env value over the file", cnf.DB.Port)
+	}
+	if cnf.DB.User != "flag-user" {
+		t.Errorf("DB.User = %q, want the flag value over env", cnf.DB.User)
+	}
+	if cnf.DB.AutoMigrate {
+		t.Errorf("DB.AutoMigrate = true, want the file value")
+	}
+	if cnf.Server.ShutdownTimeout != 10*time.Second {
+		t.Errorf("Server.ShutdownTimeout = %v, want 10s", cnf.Server.ShutdownTimeout)
+	}
+	if cnf.DB.Database != "postgres" {
+		t.Errorf("DB.Database = %q, want the default", cnf.DB.Database)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeFile(t, "imago.yaml", "smtp:\n  transport: log\n  sslmode: true\n")
+	t.Setenv(config.FileEnv, path)
+
+	cnf, err := config.Load(nil, nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cnf.SMTP.Transport != config.SMTPTransportLog || !cnf.SMTP.SSLMode {
+		t.Errorf("SMTP = %+v, want the file values", cnf.SMTP)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	t.Setenv("DB_PASSWORD_FILE", writeFile(t, "db_password", "from-env-file\n"))
+	path := writeFile(t, "imago.toml", "[smtp]\npassword_file = \""+writeFile(t, "smtp_password", "from-file")+"\"\n")
+
+	cnf, err := load(t, "-config", path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cnf.DB.Password != "from-env-file" {
+		t.Errorf("DB.Password = %q, want the contents of DB_PASSWORD_FILE", cnf.DB.Password)
+	}
+	if cnf.SMTP.Password != "from-file" {
+		t.Errorf("SMTP.Password = %q, want the contents of password_file", cnf.SMTP.Password)
+	}
+
+	t.Setenv("DB_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err = load(t); err == nil {
+		t.Errorf("Load() with a missing DB_PASSWORD_FILE error = nil")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{name: "bool", env: map[string]string{"CSRF_SECURE": "yes"}, want: "CSRF_SECURE"},
+		{name: "int", env: map[string]string{"SERVER_PORT": "http"}, want: "SERVER_PORT"},
+		{name: "port", env: map[string]string{"SERVER_PORT": "70000"}, want: "SERVER_PORT"},
+		{name: "environment", env: map[string]string{"SERVER_ENV": "staging"}, want: "SERVER_ENV"},
+		{name: "transport", env: map[string]string{"SMTP_TRANSPORT": "pigeon"}, want: "SMTP_TRANSPORT"},
+		{name: "unknown key", file: "[db]\nhostname = \"db\"\n", want: "DB_HOSTNAME"},
+		{name: "prod default key", env: map[string]string{"SERVER_ENV": "prod"}, want: "CSRF_KEY"},
+		{
+			name: "prod short key",
+			env: map[string]string{
+				"SERVER_ENV":  "prod",
+				"CSRF_KEY":    "too-short",
+				"FLASH_KEY":   strings.Repeat("f", 32),
+				"DB_PASSWORD": "secret",
+			},
+			want: "CSRF_KEY: must be at least 32 bytes",
+		},
+		{
+			name: "prod without health token",
+			env: map[string]string{
+				"SERVER_ENV":  "prod",
+				"CSRF_KEY":    strings.Repeat("c", 32),
+				"FLASH_KEY":   strings.Repeat("f", 32),
+				"DB_PASSWORD": "secret",
+			},
+			want: "HEALTH_TOKEN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var args []string
+			if tt.file != "" {
+				args = []string{"-config", writeFile(t, "imago.toml", tt.file)}
+			}
+
+			_, err := load(t, args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProd(t *testing.T) {
+	t.Setenv("SERVER_ENV", "prod")
+	t.Setenv("CSRF_KEY", strings.Repeat("c", 32))
+	t.Setenv("FLASH_KEY", strings.Repeat("f", 32))
+	t.Setenv("DB_PASSWORD", "secret")
+	t.Setenv("HEALTH_TOKEN", "secret")
+
+	if _, err := load(t); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("DB_HOST", "db.internal")
+
+	cnf, err := load(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var out strings.Builder
+	if err = cnf.Print(&out); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("Print() shows the database password:\n%s", out.String())
+	}
+	for _, want := range []string{"DB_PASSWORD=[redacted]", "DB_HOST=db.internal", "# env", "# default"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Print() does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
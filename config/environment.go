@@ -1,9 +1,7 @@
 package config
 
 import (
-	"log"
-	"os"
-	"strconv"
+	"fmt"
 	"strings"
 )
 
@@ -14,50 +12,13 @@ const (
 	Prod Environment = "prod"
 )
 
-func getEnv(key, fallback string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		log.Printf("Environment variable %s not set, using fallback: %s", key, fallback)
-		return fallback
-	}
-	return value
-}
-
-func getIntEnv(key string, fallback int) int {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		log.Printf("Environment variable %s not set, using fallback: %d", key, fallback)
-		return fallback
-	}
-	intValue, err := strconv.Atoi(value)
-	if err != nil {
-		log.Printf("Error converting environment variable %s to int: %v, using fallback: %d", key, err, fallback)
-		return fallback
-	}
-	return intValue
-}
-
-func getBoolEnv(key string, fallback bool) bool {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		log.Printf("Environment variable %s not set, using fallback: %t", key, fallback)
-		return fallback
-	}
-	if value == "true" {
-		return true
-	}
-	return false
-}
-
-func GetEnvironment(key string, fallback Environment) Environment {
-	env := os.Getenv(key)
-	switch strings.ToLower(env) {
+func parseEnvironment(value string) (Environment, error) {
+	switch strings.ToLower(value) {
 	case "dev":
-		return Dev
+		return Dev, nil
 	case "prod":
-		return Prod
+		return Prod, nil
 	default:
-		log.Printf("Unknown environment '%s', defaulting to %s", env, fallback)
-		return fallback
+		return "", fmt.Errorf("unknown environment %q, want dev or prod", value)
 	}
 }
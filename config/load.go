@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv is the environment variable with the path of the configuration file, used
+// when the -config flag is not given.
+const FileEnv = "CONFIG_FILE"
+
+// Load builds the configuration from, in increasing priority, the defaults, the
+// configuration file, environment variables and flags, and validates the result.
+//
+// Every setting has a key like DB_PASSWORD. It is set by the environment variable of
+// that name, by the flag -db-password, or by password in the [db] table of the file.
+// The environment and the file also accept the key with a _FILE suffix, naming a
+// file that holds the value, like the secrets Docker mounts in /run/secrets.
+//
+// Load defines its flags on fs and parses args with it, so callers can add their own
+// flags beforehand. Flags are skipped when fs is nil.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	path := os.Getenv(FileEnv)
+	flags := map[string]string{}
+	if fs != nil {
+		// Loading only the defaults lists every setting.
+		for _, s := range new(loader).load().settings {
+			usage := "overrides " + s.key
+			if !s.secret && s.value != "" {
+				usage += " (default " + s.value + ")"
+			}
+			fs.Func(flagName(s.key), usage, func(value string) error {
+				flags[s.key] = value
+				return nil
+			})
+		}
+		fs.StringVar(&path, "config", path, "path of a TOML or YAML configuration file, also set by "+FileEnv)
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &loader{sources: []source{
+		{name: "flag", lookup: lookupMap(flags)},
+		{name: "env", lookup: os.LookupEnv},
+	}}
+	var file map[string]string
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, err
+		}
+		l.sources = append(l.sources, source{name: path, lookup: lookupMap(file)})
+	}
+
+	cnf := l.load()
+	for key := range file {
+		if !l.known(strings.TrimSuffix(key, "_FILE")) {
+			l.errs = append(l.errs, fmt.Errorf("%s: unknown setting %s", path, key))
+		}
+	}
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, err
+	}
+	if err := cnf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cnf, nil
+}
+
+// Print writes the effective configuration as KEY=value lines, noting where each
+// value came from. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range c.settings {
+		value := s.value
+		if s.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(tw, "%s=%s\t# %s\n", s.key, value, s.source)
+	}
+
+	return tw.Flush()
+}
+
+// source is a layer of the configuration, like the environment.
+type source struct {
+	name   string
+	lookup func(key string) (string, bool)
+}
+
+// setting is a value of the effective configuration, kept for Config.Print.
+type setting struct {
+	key    string
+	value  string
+	source string
+	secret bool
+}
+
+// loader reads settings from its sources, the first one that sets a key wins.
+// Invalid values are collected in errs and replaced by the default.
+type loader struct {
+	sources  []source
+	settings []setting
+	errs     []error
+}
+
+// lookup returns the value of key, and whether a source set it rather than fallback.
+func (l *loader) lookup(key, fallback string, secret bool) (string, bool) {
+	for _, src := range l.sources {
+		if value, ok := src.lookup(key); ok {
+			l.settings = append(l.settings, setting{key: key, value: value, source: src.name, secret: secret})
+			return value, true
+		}
+
+		path, ok := src.lookup(key + "_FILE")
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s_FILE: %w", key, err))
+			break
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		name := src.name + " " + key + "_FILE"
+		l.settings = append(l.settings, setting{key: key, value: value, source: name, secret: secret})
+		return value, true
+	}
+
+	l.settings = append(l.settings, setting{key: key, value: fallback, source: "default", secret: secret})
+	return fallback, false
+}
+
+func (l *loader) known(key string) bool {
+	for _, s := range l.settings {
+		if s.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *loader) string(key, fallback string) string {
+	value, _ := l.lookup(key, fallback, false)
+	return value
+}
+
+// secret is like string, but the value is redacted by Config.Print.
+func (l *loader) secret(key, fallback string) string {
+	value, _ := l.lookup(key, fallback, true)
+	return value
+}
+
+func (l *loader) int(key string, fallback int) int {
+	return get(l, key, fallback, strconv.Itoa, strconv.Atoi)
+}
+
+func (l *loader) float(key string, fallback float64) float64 {
+	format := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	parse := func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }
+	return get(l, key, fallback, format, parse)
+}
+
+func (l *loader) bool(key string, fallback bool) bool {
+	return get(l, key, fallback, strconv.FormatBool, strconv.ParseBool)
+}
+
+func (l *loader) duration(key string, fallback time.Duration) time.Duration {
+	return get(l, key, fallback, time.Duration.String, time.ParseDuration)
+}
+
+func (l *loader) level(key string, fallback slog.Level) slog.Level {
+	parse := func(s string) (slog.Level, error) {
+		var level slog.Level
+		err := level.UnmarshalText([]byte(s))
+		return level, err
+	}
+	return get(l, key, fallback, slog.Level.String, parse)
+}
+
+func (l *loader) environment(key string, fallback Environment) Environment {
+	format := func(env Environment) string { return string(env) }
+	return get(l, key, fallback, format, parseEnvironment)
+}
+
+// get looks up key and parses its value, or returns fallback when it is not set.
+func get[T any](l *loader, key string, fallback T, format func(T) string, parse func(string) (T, error)) T {
+	value, ok := l.lookup(key, format(fallback), false)
+	if !ok {
+		return fallback
+	}
+	parsed, err := parse(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %w", key, err))
+		return fallback
+	}
+	return parsed
+}
+
+func lookupMap(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+// flagName returns the flag for a key, like -db-host for DB_HOST.
+func flagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
+
+// readFile reads a TOML or YAML configuration file, chosen by its extension. Tables
+// are flattened into keys, so host in the [db] table becomes DB_HOST.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch filepath.Ext(path) {
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format, want .toml, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err = flatten(values, "", tree); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, tree map[string]any) error {
+	for name, value := range tree {
+		key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			if err := flatten(values, key, value); err != nil {
+				return err
+			}
+		case string, bool, int, int64, uint64, float64:
+			values[key] = fmt.Sprint(value)
+		default:
+			return fmt.Errorf("%s: unsupported value %v", key, value)
+		}
+	}
+
+	return nil
+}
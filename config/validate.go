@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// The defaults of secrets only suit development, Validate refuses them in Prod.
+const (
+	defaultDBPassword = "postgres"
+	defaultCSRFKey    = "default-key"
+	defaultFlashKey   = "default-flash-key"
+)
+
+// minKeyLength is the length in bytes of the keys signing cookies in Prod.
+const minKeyLength = 32
+
+const maxPort = 65535
+
+// Validate reports every setting that is out of range or unknown, and the insecure
+// defaults that must be replaced in Prod.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.DB.Port), "DB_PORT: %d is not a port", c.DB.Port)
+	check(validPort(c.SMTP.Port), "SMTP_PORT: %d is not a port", c.SMTP.Port)
+	check(validPort(c.Server.Port), "SERVER_PORT: %d is not a port", c.Server.Port)
+	check(
+		slices.Contains(
+			[]SMTPTransport{SMTPTransportSMTP, SMTPTransportFile, SMTPTransportLog, SMTPTransportMemory},
+			c.SMTP.Transport,
+		),
+		"SMTP_TRANSPORT: unknown transport %q, want smtp, file, log or memory", c.SMTP.Transport,
+	)
+	check(
+		slices.Contains([]EventBackend{EventBackendMemory, EventBackendPostgres}, c.Events.Backend),
+		"EVENTS_BACKEND: unknown backend %q, want memory or postgres", c.Events.Backend,
+	)
+	check(
+		slices.Contains(
+			[]TracingExporter{TracingExporterNone, TracingExporterStdout, TracingExporterOTLP},
+			c.Tracing.Exporter,
+		),
+		"TRACING_EXPORTER: unknown exporter %q, want none, stdout or otlp", c.Tracing.Exporter,
+	)
+	check(
+		c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"TRACING_SAMPLE_RATIO: %v is not between 0 and 1", c.Tracing.SampleRatio,
+	)
+	check(c.Server.ShutdownTimeout >= 0, "SERVER_SHUTDOWN_TIMEOUT: must not be negative")
+	check(c.Server.DrainDelay >= 0, "SERVER_DRAIN_DELAY: must not be negative")
+
+	if c.Server.Env == Prod {
+		check(c.CSRF.Key != defaultCSRFKey, "CSRF_KEY: the default key is not allowed in prod")
+		check(
+			c.CSRF.Key == defaultCSRFKey || len(c.CSRF.Key) >= minKeyLength,
+			"CSRF_KEY: must be at least %d bytes in prod", minKeyLength,
+		)
+		check(c.Flash.Key != defaultFlashKey, "FLASH_KEY: the default key is not allowed in prod")
+		check(
+			c.Flash.Key == defaultFlashKey || len(c.Flash.Key) >= minKeyLength,
+			"FLASH_KEY: must be at least %d bytes in prod", minKeyLength,
+		)
+		check(c.DB.Password != defaultDBPassword, "DB_PASSWORD: the default password is not allowed in prod")
+		check(c.Health.Token != "", "HEALTH_TOKEN: required in prod, it protects /readyz and /metrics")
+	}
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= maxPort
+}
@@ -2,6 +2,7 @@ package context
 
 import (
 	"context"
+	"sync"
 
 	"github.com/azdanov/imago/models"
 )
@@ -11,6 +12,7 @@ type key int
 const (
 	userKey key = iota
 	notificationsKey
+	unreadNotificationsKey
 )
 
 func WithUser(ctx context.Context, user *models.User) context.Context {
@@ -46,3 +48,17 @@ func Notifications(ctx context.Context) []models.Notification {
 	}
 	return notifications
 }
+
+// WithUnreadNotifications stores how to count the unread notifications of the current
+// user. count is called at most once, when UnreadNotifications is first called.
+func WithUnreadNotifications(ctx context.Context, count func() int) context.Context {
+	return context.WithValue(ctx, unreadNotificationsKey, sync.OnceValue(count))
+}
+
+func UnreadNotifications(ctx context.Context) int {
+	count, ok := ctx.Value(unreadNotificationsKey).(func() int)
+	if !ok {
+		return 0
+	}
+	return count()
+}
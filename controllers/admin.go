@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type Admin struct {
+	Templates struct {
+		Users     Template
+		Galleries Template
+		Audit     Template
+		Jobs      Template
+	}
+
+	UserService          *models.UserService
+	SessionService       *models.SessionService
+	PasswordResetService *models.PasswordResetService
+	EmailService         *models.EmailService
+	GalleryService       *models.GalleryService
+	AuditService         *models.AuditService
+	JobService           *models.JobService
+
+	serverURL string
+}
+
+func NewAdmin(
+	us *models.UserService,
+	ss *models.SessionService,
+	ps *models.PasswordResetService,
+	es *models.EmailService,
+	gs *models.GalleryService,
+	as *models.AuditService,
+	js *models.JobService,
+	cnf *config.Config,
+) *Admin {
+	return &Admin{
+		UserService:          us,
+		SessionService:       ss,
+		PasswordResetService: ps,
+		EmailService:         es,
+		GalleryService:       gs,
+		AuditService:         as,
+		JobService:           js,
+		serverURL:            cnf.Server.GetURL(),
+	}
+}
+
+func (a Admin) Users(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	users, err := a.UserService.Search(r.Context(), query)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("search users", "error", err)
+		RedirectWithNotification(w, r, "/", ErrorNotification, "Failed to retrieve users", nil)
+		return
+	}
+
+	var data struct {
+		Query string
+		Users []models.User
+	}
+	data.Query = query
+	data.Users = users
+
+	a.Templates.Users.Execute(w, r, data)
+}
+
+func (a Admin) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == context.User(r.Context()).ID {
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "You cannot disable your own account", nil)
+		return
+	}
+
+	if err := a.UserService.Disable(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("disable user", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to disable user", nil)
+		return
+	}
+
+	recordAudit(a.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditUserDisable,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	// A disabled account must not keep any live sessions around.
+	if err := a.SessionService.DeleteByUserID(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("delete sessions", "error", err)
+	} else {
+		recordAudit(a.AuditService, r, models.AuditEvent{
+			OwnerID: &user.ID,
+			Action:  models.AuditSessionRevoke,
+			Target:  "user:" + strconv.Itoa(user.ID),
+		})
+	}
+
+	RedirectWithNotification(w, r, "/admin/users", SuccessNotification, user.Email+" has been disabled", nil)
+}
+
+func (a Admin) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.UserService.Enable(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("enable user", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to enable user", nil)
+		return
+	}
+
+	recordAudit(a.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditUserEnable,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	RedirectWithNotification(w, r, "/admin/users", SuccessNotification, user.Email+" has been re-enabled", nil)
+}
+
+func (a Admin) SignOutUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.SessionService.DeleteByUserID(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("delete sessions", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to sign out user", nil)
+		return
+	}
+
+	recordAudit(a.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditSessionRevoke,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	RedirectWithNotification(w, r, "/admin/users", SuccessNotification, user.Email+" has been signed out", nil)
+}
+
+func (a Admin) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	message := func(reset *models.PasswordReset) (models.OutboxMessage, error) {
+		resetURL := passwordResetURL(a.serverURL, reset.Token)
+		resetEmail, err := a.EmailService.ResetPasswordEmail(user.Email, user.Locale, resetURL)
+		if err != nil {
+			return models.OutboxMessage{}, err
+		}
+		return a.EmailService.Message(resetEmail), nil
+	}
+	_, err := a.PasswordResetService.Generate(r.Context(), user.Email, message)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("generate password reset", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to generate password reset", nil)
+		return
+	}
+
+	recordAudit(a.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditPasswordResetRequest,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/admin/users",
+		SuccessNotification,
+		"A password reset email has been sent to "+user.Email,
+		nil,
+	)
+}
+
+func (a Admin) Galleries(w http.ResponseWriter, r *http.Request) {
+	galleries, err := a.GalleryService.All(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("list galleries", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to retrieve galleries", nil)
+		return
+	}
+
+	var data struct {
+		Galleries []models.Gallery
+	}
+	data.Galleries = galleries
+
+	a.Templates.Galleries.Execute(w, r, data)
+}
+
+func (a Admin) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		RedirectWithNotification(w, r, "/admin/galleries", ErrorNotification, "Invalid gallery ID", nil)
+		return
+	}
+
+	gallery, err := a.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		RedirectWithNotification(w, r, "/admin/galleries", ErrorNotification, "Gallery not found", nil)
+		return
+	}
+
+	if err = a.GalleryService.Delete(r.Context(), gallery.ID); err != nil {
+		logging.FromContext(r.Context()).Error("delete gallery", "error", err)
+		RedirectWithNotification(w, r, "/admin/galleries", ErrorNotification, "Failed to delete gallery", nil)
+		return
+	}
+
+	recordAudit(a.AuditService, r, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditGalleryDelete,
+		Target:   "gallery:" + strconv.Itoa(gallery.ID),
+		Metadata: map[string]string{"title": gallery.Title},
+	})
+
+	RedirectWithNotification(w, r, "/admin/galleries", SuccessNotification, "Gallery deleted successfully", nil)
+}
+
+func (a Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	events, err := a.AuditService.All(r.Context(), models.DefaultAuditLimit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("audit events", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to retrieve audit log", nil)
+		return
+	}
+
+	var data struct {
+		AuditEvents []models.AuditEvent
+	}
+	data.AuditEvents = events
+
+	a.Templates.Audit.Execute(w, r, data)
+}
+
+func (a Admin) Jobs(w http.ResponseWriter, r *http.Request) {
+	counts, err := a.JobService.Counts(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("count jobs", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to retrieve jobs", nil)
+		return
+	}
+
+	pending, err := a.JobService.ByStatus(r.Context(), models.DefaultJobListLimit, models.JobQueued, models.JobRunning)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("pending jobs", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to retrieve jobs", nil)
+		return
+	}
+
+	dead, err := a.JobService.ByStatus(r.Context(), models.DefaultJobListLimit, models.JobDead)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("dead jobs", "error", err)
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Failed to retrieve jobs", nil)
+		return
+	}
+
+	var data struct {
+		Queued, Running, Done, Failed int
+
+		Pending []models.Job
+		Dead    []models.Job
+	}
+	data.Queued = counts[models.JobQueued]
+	data.Running = counts[models.JobRunning]
+	data.Done = counts[models.JobDone]
+	data.Failed = counts[models.JobDead]
+	data.Pending = pending
+	data.Dead = dead
+
+	a.Templates.Jobs.Execute(w, r, data)
+}
+
+func (a Admin) RetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RedirectWithNotification(w, r, "/admin/jobs", ErrorNotification, "Invalid job ID", nil)
+		return
+	}
+
+	if err = a.JobService.Retry(r.Context(), jobID); err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			logging.FromContext(r.Context()).Error("retry job", "error", err)
+		}
+		RedirectWithNotification(w, r, "/admin/jobs", ErrorNotification, "Failed to retry job", nil)
+		return
+	}
+
+	RedirectWithNotification(w, r, "/admin/jobs", SuccessNotification, "Job has been queued again", nil)
+}
+
+// userFromURL looks up the user referenced by the {id} URL parameter. When the
+// lookup fails it redirects back to the user list and reports false.
+func (a Admin) userFromURL(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "Invalid user ID", nil)
+		return nil, false
+	}
+
+	user, err := a.UserService.ByID(r.Context(), userID)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			logging.FromContext(r.Context()).Error("get user", "error", err)
+		}
+		RedirectWithNotification(w, r, "/admin/users", ErrorNotification, "User not found", nil)
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// recordAudit fills in the actor, IP and request ID from the request and stores the event.
+// Failures are only logged, so auditing never breaks the request it describes.
+func recordAudit(as *models.AuditService, r *http.Request, event models.AuditEvent) {
+	if as == nil {
+		return
+	}
+
+	if event.ActorID == nil {
+		if user := context.User(r.Context()); user != nil {
+			actorID := user.ID
+			event.ActorID = &actorID
+		}
+	}
+
+	event.IP = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		event.IP = host
+	}
+	event.RequestID = middleware.GetReqID(r.Context())
+
+	if err := as.Record(r.Context(), event); err != nil {
+		logging.FromContext(r.Context()).Error("record audit event", "action", event.Action, "error", err)
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// DefaultEventHeartbeat is how often an idle stream sends a comment, so proxies
+	// and browsers do not drop the connection.
+	DefaultEventHeartbeat = 15 * time.Second
+	// eventRetry tells the browser how long to wait before reconnecting a closed stream.
+	eventRetry = 3 * time.Second
+)
+
+// Events streams live updates as Server-Sent Events. Browsers reconnect on their
+// own, so a stream simply ends when the client goes away or the broker is closed.
+type Events struct {
+	EventBroker    *models.EventBroker
+	GalleryService *models.GalleryService
+	// Heartbeat defaults to DefaultEventHeartbeat.
+	Heartbeat time.Duration
+}
+
+func NewEvents(eb *models.EventBroker, gs *models.GalleryService) *Events {
+	return &Events{
+		EventBroker:    eb,
+		GalleryService: gs,
+		Heartbeat:      DefaultEventHeartbeat,
+	}
+}
+
+// User streams the events of the current user, like new notifications.
+func (e Events) User(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	e.stream(w, r, models.UserTopic(user.ID))
+}
+
+// Gallery streams changes to a gallery and its images. Anyone who can view the
+// gallery can follow it.
+func (e Events) Gallery(w http.ResponseWriter, r *http.Request) {
+	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusBadRequest)
+		return
+	}
+
+	gallery, err := e.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("gallery events", "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	e.stream(w, r, models.GalleryTopic(gallery.ID))
+}
+
+func (e Events) stream(w http.ResponseWriter, r *http.Request, topic string) {
+	rc := http.NewResponseController(w)
+	// The server write timeout would otherwise cut every stream after a few seconds.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logging.FromContext(r.Context()).Error("event stream", "error", err)
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := e.EventBroker.Subscribe(topic)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(e.heartbeat())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (e Events) heartbeat() time.Duration {
+	if e.Heartbeat <= 0 {
+		return DefaultEventHeartbeat
+	}
+	return e.Heartbeat
+}
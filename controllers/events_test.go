@@ -0,0 +1,67 @@
+package controllers_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/models"
+)
+
+func TestEventsStream(t *testing.T) {
+	broker := models.NewEventBroker()
+	events := controllers.NewEvents(broker, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithUser(r.Context(), &models.User{ID: 1})
+		events.User(w, r.WithContext(ctx))
+	}))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	body := bufio.NewReader(res.Body)
+	// frame reads the lines up to the blank line ending an event stream frame.
+	frame := func() []string {
+		t.Helper()
+		var lines []string
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read frame: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if got := frame(); len(got) != 1 || got[0] != "retry: 3000" {
+		t.Errorf("first frame = %q, want the retry interval", got)
+	}
+
+	// The stream subscribed before sending the retry frame.
+	broker.Publish(t.Context(), models.UserTopic(2), models.EventNotificationCreated, "not for this user")
+	broker.Publish(t.Context(), models.UserTopic(1), models.EventNotificationCreated, map[string]int{"id": 7})
+	want := []string{"id: 2", "event: notification.created", `data: {"id":7}`}
+	if got := frame(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("event frame = %q, want %q", got, want)
+	}
+
+	broker.Close()
+	if line, err := body.ReadString('\n'); err == nil {
+		t.Errorf("stream sent %q after the broker closed, want it to end", line)
+	}
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/azdanov/imago/models"
+)
+
+const FlashName = "flash"
+
+var errInvalidFlash = errors.New("invalid flash cookie")
+
+// FlashCookie carries notifications to the next page in a signed cookie that is
+// cleared as soon as it is read. Unlike query parameters, the messages cannot be
+// forged through a crafted link and do not end up in browser history.
+type FlashCookie struct {
+	// Key signs the cookie value.
+	Key    []byte
+	Secure bool
+}
+
+func NewFlashCookie(key []byte, secure bool) *FlashCookie {
+	return &FlashCookie{
+		Key:    key,
+		Secure: secure,
+	}
+}
+
+func (c FlashCookie) new(value string) http.Cookie {
+	cookie := http.Cookie{
+		Name:     FlashName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie
+}
+
+func (c FlashCookie) Set(w http.ResponseWriter, notifications []models.Notification) error {
+	payload, err := json.Marshal(notifications)
+	if err != nil {
+		return fmt.Errorf("set flash: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	cookie := c.new(encoded + "." + c.sign(encoded))
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// Get returns the notifications stored in the cookie. A cookie with a missing or
+// wrong signature is rejected.
+func (c FlashCookie) Get(r *http.Request) ([]models.Notification, error) {
+	cookie, err := r.Cookie(FlashName)
+	if err != nil {
+		return nil, fmt.Errorf("get flash: %w", err)
+	}
+
+	encoded, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(c.sign(encoded))) {
+		return nil, fmt.Errorf("get flash: %w", errInvalidFlash)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("get flash: %w", err)
+	}
+
+	var notifications []models.Notification
+	if err = json.Unmarshal(payload, &notifications); err != nil {
+		return nil, fmt.Errorf("get flash: %w", err)
+	}
+
+	return notifications, nil
+}
+
+func (c FlashCookie) Clear(w http.ResponseWriter) {
+	cookie := c.new("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+}
+
+func (c FlashCookie) sign(value string) string {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(FlashName + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,110 @@
+package controllers_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/models"
+)
+
+var flashKey = []byte(strings.Repeat("k", 32))
+
+// flashValue returns the value of the flash cookie fc sets for notifications.
+func flashValue(t *testing.T, fc *controllers.FlashCookie, notifications ...models.Notification) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := fc.Set(w, notifications); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == controllers.FlashName {
+			return cookie.Value
+		}
+	}
+	t.Fatal("Set() sent no flash cookie")
+	return ""
+}
+
+func requestWithFlash(value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: controllers.FlashName, Value: value})
+	return r
+}
+
+func TestFlashCookie(t *testing.T) {
+	fc := controllers.NewFlashCookie(flashKey, false)
+	saved := models.Notification{Type: "success", Message: "Gallery saved"}
+	value := flashValue(t, fc, saved)
+	encoded, signature, _ := strings.Cut(value, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`[{"Type":"error","Message":"Call 555-0100"}]`))
+
+	notifications, err := fc.Get(requestWithFlash(value))
+	if err != nil || len(notifications) != 1 || notifications[0] != saved {
+		t.Fatalf("Get() = %v, %v, want %v", notifications, err, saved)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "tampered payload", value: forged + "." + signature},
+		{name: "wrong key", value: flashValue(t, controllers.NewFlashCookie([]byte("other key"), false), saved)},
+		{name: "missing signature", value: encoded},
+		{name: "empty signature", value: encoded + "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if notifications, err := fc.Get(requestWithFlash(tt.value)); err == nil {
+				t.Errorf("Get() = %v, want an error", notifications)
+			}
+		})
+	}
+}
+
+func TestExtractNotifications(t *testing.T) {
+	fc := controllers.NewFlashCookie(flashKey, false)
+	mw := controllers.NewNotificationMiddleware(fc)
+	var got []models.Notification
+	handler := mw.ExtractNotifications(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = context.Notifications(r.Context())
+	}))
+
+	// cleared reports whether the response deletes the flash cookie.
+	cleared := func(w *httptest.ResponseRecorder) bool {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == controllers.FlashName && cookie.MaxAge < 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	saved := models.Notification{Type: "success", Message: "Gallery saved"}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, requestWithFlash(flashValue(t, fc, saved)))
+	if len(got) != 1 || got[0] != saved {
+		t.Errorf("notifications = %v, want %v", got, saved)
+	}
+	if !cleared(w) {
+		t.Error("flash cookie not cleared after it was read")
+	}
+
+	// The browser drops the cleared cookie, so the next page shows nothing.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(got) != 0 {
+		t.Errorf("notifications on the next page = %v, want none", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, requestWithFlash("forged.signature"))
+	if len(got) != 0 || !cleared(w) {
+		t.Errorf("forged cookie gave notifications %v, cleared = %v, want none and cleared", got, cleared(w))
+	}
+}
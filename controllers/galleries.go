@@ -3,32 +3,46 @@ package controllers
 import (
 	"errors"
 	"fmt"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/metrics"
 	"github.com/azdanov/imago/models"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
-const maxFileSize = 5 << 20 // 5 MB
+const (
+	maxFileSize   = 5 << 20   // 5 MB
+	maxImportSize = 200 << 20 // 200 MB
+
+	// downloadTimeout and importTimeout replace the server timeouts for archive
+	// downloads and imports, which can take much longer than a regular page.
+	downloadTimeout = 10 * time.Minute
+	importTimeout   = 10 * time.Minute
+)
 
 type Galleries struct {
 	Templates struct {
-		New  Template
-		Edit Template
-		Show Template
-		List Template
+		New    Template
+		Edit   Template
+		Show   Template
+		List   Template
+		Import Template
 	}
 	GalleryService *models.GalleryService
+	AuditService   *models.AuditService
 }
 
-func NewGalleries(gs *models.GalleryService) *Galleries {
+func NewGalleries(gs *models.GalleryService, as *models.AuditService) *Galleries {
 	return &Galleries{
 		GalleryService: gs,
+		AuditService:   as,
 	}
 }
 
@@ -50,31 +64,32 @@ func (g Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	data.Title = r.FormValue("title")
 
 	if data.Title == "" {
-		vals := url.Values{
-			models.NotificationError: {"Title is required"},
-		}
-		http.Redirect(w, r, "/galleries/new?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries/new", ErrorNotification, "Title is required", nil)
 		return
 	}
 
-	gallery, err := g.GalleryService.Create(data.Title, data.UserID)
+	gallery, err := g.GalleryService.Create(r.Context(), data.Title, data.UserID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to create gallery"},
-		}
-		http.Redirect(w, r, "/galleries/new?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries/new", ErrorNotification, "Failed to create gallery", nil)
 		return
 	}
 
 	galleryID := strconv.Itoa(gallery.ID)
 
+	recordAudit(g.AuditService, r, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditGalleryCreate,
+		Target:   "gallery:" + galleryID,
+		Metadata: map[string]string{"title": gallery.Title},
+	})
+
 	http.Redirect(w, r, "/galleries/"+galleryID+"/edit", http.StatusSeeOther)
 }
 
 func (g Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 	data, err := g.fetchGalleryData(r)
 	if err != nil {
-		http.Redirect(w, r, "/galleries?"+err.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, err.Error(), nil)
 		return
 	}
 
@@ -84,10 +99,7 @@ func (g Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 func (g Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
 		return
 	}
 
@@ -97,57 +109,87 @@ func (g Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	data.Title = r.FormValue("title")
 
 	if data.Title == "" {
-		vals := url.Values{
-			models.NotificationError: {"Title is required"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"Title is required",
+			nil,
+		)
 		return
 	}
 
-	gallery, err := g.GalleryService.ByID(galleryID)
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Gallery not found"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"Gallery not found",
+			nil,
+		)
 		return
 	}
 
 	if gallery.UserID != context.User(r.Context()).ID {
-		vals := url.Values{
-			models.NotificationError: {"You do not have permission to edit this gallery"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"You do not have permission to edit this gallery",
+			nil,
+		)
 		return
 	}
 
+	previousTitle := gallery.Title
 	gallery.Title = data.Title
 
-	err = g.GalleryService.Update(gallery)
+	err = g.GalleryService.Update(r.Context(), gallery)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to update gallery"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"Failed to update gallery",
+			nil,
+		)
 		return
 	}
 
-	vals := url.Values{
-		models.NotificationSuccess: {"Gallery updated successfully"},
-	}
-	http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+	recordAudit(g.AuditService, r, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditGalleryUpdate,
+		Target:   "gallery:" + strconv.Itoa(gallery.ID),
+		Metadata: map[string]string{"previous_title": previousTitle, "title": gallery.Title},
+	})
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+		SuccessNotification,
+		"Gallery updated successfully",
+		nil,
+	)
 }
 
 func (g Galleries) Show(w http.ResponseWriter, r *http.Request) {
 	data, err := g.fetchGalleryData(r)
 	if err != nil {
-		http.Redirect(w, r, "/galleries?"+err.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, err.Error(), nil)
 		return
 	}
 
 	g.Templates.Show.Execute(w, r, data)
 }
 
+// fetchGalleryData loads the gallery referenced by the {id} URL parameter. The
+// returned error message is meant to be shown to the user.
 func (g Galleries) fetchGalleryData(r *http.Request) (struct {
 	ID     int
 	Title  string
@@ -155,7 +197,7 @@ func (g Galleries) fetchGalleryData(r *http.Request) (struct {
 		Filename        string
 		EscapedFilename string
 	}
-}, url.Values,
+}, error,
 ) {
 	var data struct {
 		ID     int
@@ -168,26 +210,20 @@ func (g Galleries) fetchGalleryData(r *http.Request) (struct {
 
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		return data, url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
+		return data, errors.New("Invalid gallery ID")
 	}
 
-	gallery, err := g.GalleryService.ByID(galleryID)
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
 	if err != nil {
-		return data, url.Values{
-			models.NotificationError: {"Gallery not found"},
-		}
+		return data, errors.New("Gallery not found")
 	}
 
 	data.ID = gallery.ID
 	data.Title = gallery.Title
 
-	images, err := g.GalleryService.Images(gallery.ID)
+	images, err := g.GalleryService.Images(r.Context(), gallery.ID)
 	if err != nil {
-		return data, url.Values{
-			models.NotificationError: {"Failed to retrieve images"},
-		}
+		return data, errors.New("Failed to retrieve images")
 	}
 
 	for _, image := range images {
@@ -204,12 +240,9 @@ func (g Galleries) fetchGalleryData(r *http.Request) (struct {
 }
 
 func (g Galleries) List(w http.ResponseWriter, r *http.Request) {
-	galleries, err := g.GalleryService.ByUserID(context.User(r.Context()).ID)
+	galleries, err := g.GalleryService.ByUserID(r.Context(), context.User(r.Context()).ID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to retrieve galleries"},
-		}
-		http.Redirect(w, r, "/?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/", ErrorNotification, "Failed to retrieve galleries", nil)
 		return
 	}
 
@@ -224,68 +257,67 @@ func (g Galleries) List(w http.ResponseWriter, r *http.Request) {
 func (g Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
 		return
 	}
 
-	gallery, err := g.GalleryService.ByID(galleryID)
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Gallery not found"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Gallery not found", nil)
 		return
 	}
 
 	if gallery.UserID != context.User(r.Context()).ID {
-		vals := url.Values{
-			models.NotificationError: {"You do not have permission to delete this gallery"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"You do not have permission to delete this gallery",
+			nil,
+		)
 		return
 	}
 
-	err = g.GalleryService.Delete(galleryID)
+	err = g.GalleryService.Delete(r.Context(), galleryID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to delete gallery"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Failed to delete gallery", nil)
 		return
 	}
 
+	recordAudit(g.AuditService, r, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditGalleryDelete,
+		Target:   "gallery:" + strconv.Itoa(gallery.ID),
+		Metadata: map[string]string{"title": gallery.Title},
+	})
+
 	http.Redirect(w, r, "/galleries", http.StatusSeeOther)
 }
 
 func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
 		return
 	}
 
-	gallery, err := g.GalleryService.ByID(galleryID)
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Gallery not found"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Gallery not found", nil)
 		return
 	}
 
 	err = r.ParseMultipartForm(maxFileSize)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to parse form. Max file size is " +
-				strconv.Itoa(maxFileSize) + " bytes."},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+			ErrorNotification,
+			"Failed to parse form. Max file size is "+strconv.Itoa(maxFileSize)+" bytes.",
+			nil,
+		)
 		return
 	}
 
@@ -294,39 +326,184 @@ func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
 		var file multipart.File
 		file, err = fileHeader.Open()
 		if err != nil {
-			vals := url.Values{
-				models.NotificationError: {"Failed to open file"},
-			}
-			http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+			RedirectWithNotification(
+				w,
+				r,
+				"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+				ErrorNotification,
+				"Failed to open file",
+				nil,
+			)
 			return
 		}
 
 		defer file.Close()
 
-		err = g.GalleryService.CreateImage(gallery.ID, fileHeader.Filename, file)
+		err = g.GalleryService.CreateImage(r.Context(), gallery.ID, fileHeader.Filename, file)
 		if err != nil {
 			var fileErr models.FileError
 			if errors.As(err, &fileErr) {
-				vals := url.Values{
-					models.NotificationError: {fmt.Sprintf(`%v has an invalid content type or extension. 
-					Only %s files can be uploaded.`, fileHeader.Filename, g.GalleryService.Extensions())},
-				}
-				http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+				RedirectWithNotification(
+					w,
+					r,
+					"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+					ErrorNotification,
+					fmt.Sprintf("%v has an invalid content type or extension. Only %s files can be uploaded.",
+						fileHeader.Filename, g.GalleryService.Extensions()),
+					nil,
+				)
 				return
 			}
 
-			vals := url.Values{
-				models.NotificationError: {"Failed to upload image"},
-			}
-			http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+			RedirectWithNotification(
+				w,
+				r,
+				"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+				ErrorNotification,
+				"Failed to upload image",
+				nil,
+			)
 			return
 		}
+
+		recordAudit(g.AuditService, r, models.AuditEvent{
+			OwnerID: &gallery.UserID,
+			Action:  models.AuditImageUpload,
+			Target:  "gallery:" + strconv.Itoa(gallery.ID),
+			Metadata: map[string]string{
+				"filename": fileHeader.Filename,
+				"size":     strconv.FormatInt(fileHeader.Size, 10),
+			},
+		})
+	}
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+		SuccessNotification,
+		"Image uploaded successfully",
+		nil,
+	)
+}
+
+func (g Galleries) ImportZip(w http.ResponseWriter, r *http.Request) {
+	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
+		return
+	}
+
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Gallery not found", nil)
+		return
+	}
+
+	editURL := "/galleries/" + strconv.Itoa(gallery.ID) + "/edit"
+
+	if gallery.UserID != context.User(r.Context()).ID {
+		RedirectWithNotification(
+			w,
+			r,
+			editURL,
+			ErrorNotification,
+			"You do not have permission to edit this gallery",
+			nil,
+		)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err = rc.SetReadDeadline(time.Now().Add(importTimeout)); err != nil {
+		logging.FromContext(r.Context()).Warn("set read deadline", "error", err)
+	}
+	if err = rc.SetWriteDeadline(time.Now().Add(importTimeout)); err != nil {
+		logging.FromContext(r.Context()).Warn("set write deadline", "error", err)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err = r.ParseMultipartForm(maxFileSize)
+	if err != nil {
+		RedirectWithNotification(
+			w,
+			r,
+			editURL,
+			ErrorNotification,
+			"Failed to parse form. Max archive size is "+strconv.Itoa(maxImportSize)+" bytes.",
+			nil,
+		)
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("archive")
+	if err != nil {
+		RedirectWithNotification(w, r, editURL, ErrorNotification, "Please select a ZIP archive to import", nil)
+		return
+	}
+	defer file.Close()
+
+	results, err := g.GalleryService.ImportZip(r.Context(), gallery.ID, file, fileHeader.Size)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("import zip", "error", err)
+		message := "Failed to read the ZIP archive"
+		if errors.Is(err, models.ErrImportLimit) {
+			message = fmt.Sprintf("The archive is too large. At most %d files and %d bytes uncompressed are allowed.",
+				models.MaxImportEntries, models.MaxImportUncompressedSize)
+		}
+		RedirectWithNotification(w, r, editURL, ErrorNotification, message, nil)
+		return
 	}
 
-	vals := url.Values{
-		models.NotificationSuccess: {"Image uploaded successfully"},
+	type result struct {
+		Name     string
+		Filename string
+		Error    string
 	}
-	http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+
+	var data struct {
+		ID        int
+		Title     string
+		Imported  int
+		Failed    int
+		Results   []result
+		Allowed   []string
+		Requested string
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Allowed = g.GalleryService.Extensions()
+	data.Requested = fileHeader.Filename
+
+	for _, res := range results {
+		if res.Err != nil {
+			data.Failed++
+			message := "Failed to import file"
+			var fileErr models.FileError
+			if errors.As(res.Err, &fileErr) {
+				message = fileErr.Error()
+			} else {
+				logging.FromContext(r.Context()).Error("import file", "file", res.Name, "error", res.Err)
+			}
+			data.Results = append(data.Results, result{Name: res.Name, Error: message})
+			continue
+		}
+
+		data.Imported++
+		data.Results = append(data.Results, result{Name: res.Name, Filename: res.Filename})
+
+		recordAudit(g.AuditService, r, models.AuditEvent{
+			OwnerID: &gallery.UserID,
+			Action:  models.AuditImageUpload,
+			Target:  "gallery:" + strconv.Itoa(gallery.ID),
+			Metadata: map[string]string{
+				"filename": res.Filename,
+				"archive":  fileHeader.Filename,
+			},
+		})
+	}
+
+	g.Templates.Import.Execute(w, r, data)
 }
 
 func (g Galleries) Image(w http.ResponseWriter, r *http.Request) {
@@ -334,64 +511,115 @@ func (g Galleries) Image(w http.ResponseWriter, r *http.Request) {
 
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
 		return
 	}
 
-	image, err := g.GalleryService.Image(galleryID, filename)
+	image, err := g.GalleryService.Image(r.Context(), galleryID, filename)
 	if err != nil {
-		log.Printf("retrieving image: %v", err)
+		logging.FromContext(r.Context()).Error("retrieving image", "error", err)
 		if errors.Is(err, models.ErrNotFound) {
-			vals := url.Values{
-				models.NotificationError: {"Image not found"},
-			}
-			http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+			RedirectWithNotification(
+				w,
+				r,
+				"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+				ErrorNotification,
+				"Image not found",
+				nil,
+			)
 			return
 		}
-		vals := url.Values{
-			models.NotificationError: {"Something went wrong"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+			ErrorNotification,
+			"Something went wrong",
+			nil,
+		)
+		return
+	}
+
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	http.ServeFile(ww, r, image.Path)
+
+	if ww.Status() == http.StatusNotModified {
+		metrics.ImageServes.WithLabelValues("hit").Inc()
+	} else {
+		metrics.ImageServes.WithLabelValues("miss").Inc()
+	}
+}
+
+func (g Galleries) Download(w http.ResponseWriter, r *http.Request) {
+	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
+		return
+	}
+
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Gallery not found", nil)
 		return
 	}
 
-	http.ServeFile(w, r, image.Path)
+	rc := http.NewResponseController(w)
+	if err = rc.SetWriteDeadline(time.Now().Add(downloadTimeout)); err != nil {
+		logging.FromContext(r.Context()).Warn("set write deadline", "error", err)
+	}
+
+	filename := fmt.Sprintf("gallery-%d.zip", gallery.ID)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	withManifest := r.URL.Query().Get("manifest") == "true"
+
+	// Headers are already sent once streaming starts, so failures can only be logged.
+	if err = g.GalleryService.WriteArchive(r.Context(), w, gallery, withManifest); err != nil {
+		logging.FromContext(r.Context()).Error("write gallery archive", "error", err)
+	}
 }
 
 func (g Galleries) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Invalid gallery ID"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Invalid gallery ID", nil)
 		return
 	}
 
-	gallery, err := g.GalleryService.ByID(galleryID)
+	gallery, err := g.GalleryService.ByID(r.Context(), galleryID)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Gallery not found"},
-		}
-		http.Redirect(w, r, "/galleries?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/galleries", ErrorNotification, "Gallery not found", nil)
 		return
 	}
 
 	filename := chi.URLParam(r, "filename")
-	err = g.GalleryService.DeleteImage(gallery.ID, filename)
+	err = g.GalleryService.DeleteImage(r.Context(), gallery.ID, filename)
 	if err != nil {
-		vals := url.Values{
-			models.NotificationError: {"Failed to delete image"},
-		}
-		http.Redirect(w, r, "/galleries/"+strconv.Itoa(gallery.ID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(
+			w,
+			r,
+			"/galleries/"+strconv.Itoa(gallery.ID)+"/edit",
+			ErrorNotification,
+			"Failed to delete image",
+			nil,
+		)
 		return
 	}
 
-	vals := url.Values{
-		models.NotificationSuccess: {"Image deleted successfully"},
-	}
-	http.Redirect(w, r, "/galleries/"+strconv.Itoa(galleryID)+"/edit?"+vals.Encode(), http.StatusSeeOther)
+	recordAudit(g.AuditService, r, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditImageDelete,
+		Target:   "gallery:" + strconv.Itoa(gallery.ID),
+		Metadata: map[string]string{"filename": filename},
+	})
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/galleries/"+strconv.Itoa(galleryID)+"/edit",
+		SuccessNotification,
+		"Image deleted successfully",
+		nil,
+	)
 }
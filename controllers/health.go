@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/logging"
+)
+
+// DefaultHealthTimeout is how long each readiness check may take.
+const DefaultHealthTimeout = 2 * time.Second
+
+// HealthCheck reports a problem with a dependency by returning an error.
+type HealthCheck func(ctx context.Context) error
+
+// Health serves the liveness and readiness probes. Once Drain is called, Ready reports
+// the server as unavailable so load balancers stop routing to it while in-flight
+// requests finish.
+type Health struct {
+	// Checks run concurrently on every readiness probe, keyed by name.
+	Checks map[string]HealthCheck
+	// Noncritical checks run and are reported like Checks, but when they fail the
+	// report is only degraded and the server stays ready. They suit dependencies
+	// whose work can wait, like the mail server behind the email outbox.
+	Noncritical map[string]HealthCheck
+	// Timeout defaults to DefaultHealthTimeout.
+	Timeout time.Duration
+	// Token, when set, must be sent as a bearer token in the Authorization header to
+	// see the readiness report and the handlers wrapped by Protect.
+	Token string
+
+	draining atomic.Bool
+}
+
+func NewHealth(token string) *Health {
+	return &Health{
+		Checks:      map[string]HealthCheck{},
+		Noncritical: map[string]HealthCheck{},
+		Timeout:     DefaultHealthTimeout,
+		Token:       token,
+	}
+}
+
+// Drain marks the server as shutting down. It cannot be undone.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkReport `json:"checks,omitempty"`
+}
+
+type checkReport struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+	// Critical is false for Noncritical checks.
+	Critical bool `json:"critical"`
+}
+
+// Live reports that the process is up and able to serve requests.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// Ready runs every check and reports whether the server can take traffic, with the
+// result of each check. A failing critical check makes the server unavailable, a
+// failing noncritical one only degrades the report.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeHealth(w, r, http.StatusUnauthorized, healthReport{Status: "unauthorized"})
+		return
+	}
+
+	if h.Draining() {
+		writeHealth(w, r, http.StatusServiceUnavailable, healthReport{Status: "draining"})
+		return
+	}
+
+	report := healthReport{Status: "ok", Checks: map[string]checkReport{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	runAll := func(checks map[string]HealthCheck, critical bool) {
+		for name, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := h.run(r.Context(), check)
+				result.Critical = critical
+
+				mu.Lock()
+				defer mu.Unlock()
+				report.Checks[name] = result
+				switch {
+				case result.Error == "":
+				case critical:
+					report.Status = "unavailable"
+				case report.Status == "ok":
+					report.Status = "degraded"
+				}
+			}()
+		}
+	}
+	runAll(h.Checks, true)
+	runAll(h.Noncritical, false)
+	wg.Wait()
+
+	status := http.StatusOK
+	if report.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, r, status, report)
+}
+
+// Protect requires the health token on next, like on the readiness report. It guards
+// operational endpoints such as the metrics, which should not be public.
+func (h *Health) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Health) run(ctx context.Context, check HealthCheck) checkReport {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := checkReport{Status: "ok", Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (h *Health) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return true
+	}
+
+	// Only the header is accepted, a token in the URL would end up in access logs.
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, status int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(r.Context()).Error("write health report", "error", err)
+	}
+}
+
+// DatabaseCheck verifies that the database accepts queries.
+func DatabaseCheck(db *sql.DB) HealthCheck {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// MigrationsCheck fails while the database schema is behind the embedded migrations.
+func MigrationsCheck(db *sql.DB, fsys fs.FS, dir string) HealthCheck {
+	return func(ctx context.Context) error {
+		current, latest, err := database.Versions(ctx, db, fsys, dir)
+		if err != nil {
+			return err
+		}
+		if current < latest {
+			return fmt.Errorf("%d pending migrations, schema version %d of %d", latest-current, current, latest)
+		}
+		return nil
+	}
+}
+
+// StorageCheck verifies that files can be created in dir.
+func StorageCheck(dir string) HealthCheck {
+	return func(context.Context) error {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+
+		return os.Remove(f.Name())
+	}
+}
+
+// SMTPCheck verifies that the SMTP server accepts connections.
+func SMTPCheck(cnf config.SMTPConfig) HealthCheck {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cnf.Host, strconv.Itoa(cnf.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
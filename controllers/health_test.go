@@ -0,0 +1,129 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/azdanov/imago/controllers"
+)
+
+func TestHealthReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("down") }
+
+	tests := []struct {
+		name        string
+		checks      map[string]controllers.HealthCheck
+		noncritical map[string]controllers.HealthCheck
+		wantCode    int
+		wantStatus  string
+	}{
+		{
+			name:        "all pass",
+			checks:      map[string]controllers.HealthCheck{"database": ok},
+			noncritical: map[string]controllers.HealthCheck{"smtp": ok},
+			wantCode:    http.StatusOK,
+			wantStatus:  "ok",
+		},
+		{
+			name:        "noncritical fails",
+			checks:      map[string]controllers.HealthCheck{"database": ok},
+			noncritical: map[string]controllers.HealthCheck{"smtp": down},
+			wantCode:    http.StatusOK,
+			wantStatus:  "degraded",
+		},
+		{
+			name:        "critical fails",
+			checks:      map[string]controllers.HealthCheck{"database": down},
+			noncritical: map[string]controllers.HealthCheck{"smtp": down},
+			wantCode:    http.StatusServiceUnavailable,
+			wantStatus:  "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := controllers.NewHealth("")
+			h.Checks = tt.checks
+			h.Noncritical = tt.noncritical
+
+			rec := httptest.NewRecorder()
+			h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var report struct {
+				Status string `json:"status"`
+				Checks map[string]struct {
+					Status string `json:"status"`
+				} `json:"checks"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", report.Status, tt.wantStatus)
+			}
+			if len(report.Checks) != len(tt.checks)+len(tt.noncritical) {
+				t.Errorf("checks = %v, want every check reported", report.Checks)
+			}
+		})
+	}
+}
+
+func TestHealthReadyToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   int
+	}{
+		{name: "bearer token", target: "/readyz", header: "Bearer secret", want: http.StatusOK},
+		{name: "wrong token", target: "/readyz", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "missing token", target: "/readyz", want: http.StatusUnauthorized},
+		{name: "query token", target: "/readyz?token=secret", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := controllers.NewHealth("secret")
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			h.Ready(rec, r)
+
+			if rec.Code != tt.want {
+				t.Errorf("code = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthProtect(t *testing.T) {
+	h := controllers.NewHealth("secret")
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	h.Protect(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.Protect(next).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with token: code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
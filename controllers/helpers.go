@@ -5,30 +5,22 @@ import (
 	"net/url"
 )
 
-// RedirectWithNotification redirects to the given path with notification parameters.
+// RedirectWithNotification redirects to the given path and shows the notification on
+// the next page through the flash cookie. Additional parameters, like form values to
+// fill in again, are added to the query string.
 func RedirectWithNotification(
 	w http.ResponseWriter,
 	r *http.Request,
 	path string,
 	notificationType NotificationType,
 	message string,
-	params map[string]string,
+	params url.Values,
 ) {
-	values := url.Values{}
+	addFlash(w, r, notificationType, message)
 
-	switch notificationType {
-	case ErrorNotification:
-		values.Set("error", message)
-	case SuccessNotification:
-		values.Set("success", message)
-	case InfoNotification:
-		values.Set("info", message)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
 	}
 
-	// Add any additional parameters
-	for k, v := range params {
-		values.Set(k, v)
-	}
-
-	http.Redirect(w, r, path+"?"+values.Encode(), http.StatusSeeOther)
+	http.Redirect(w, r, path, http.StatusSeeOther)
 }
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/azdanov/imago/logging"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestLogger stores a logger tagged with the request ID in the request context and
+// writes one access log line per request. It must run after middleware.RequestID.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ctx := logging.WithLogger(r.Context(), logger.With("request_id", middleware.GetReqID(r.Context())))
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			logging.FromContext(ctx).LogAttrs(ctx, level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
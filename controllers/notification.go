@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	stdcontext "context"
+	"errors"
 	"net/http"
 
 	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
 	"github.com/azdanov/imago/models"
 )
 
@@ -15,38 +18,59 @@ const (
 	InfoNotification    NotificationType = "info"
 )
 
-// NotificationMiddleware extracts notification parameters from URL query and adds them to the request context.
-type NotificationMiddleware struct{}
+type flashKey struct{}
 
-func NewNotificationMiddleware() *NotificationMiddleware {
-	return &NotificationMiddleware{}
+// NotificationMiddleware moves notifications from the flash cookie into the request
+// context and makes the cookie available to RedirectWithNotification.
+type NotificationMiddleware struct {
+	FlashCookie *FlashCookie
+}
+
+func NewNotificationMiddleware(fc *FlashCookie) *NotificationMiddleware {
+	return &NotificationMiddleware{
+		FlashCookie: fc,
+	}
 }
 
 func (m *NotificationMiddleware) ExtractNotifications(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		if errorMsg := r.URL.Query().Get("error"); errorMsg != "" {
-			ctx = context.AddNotification(ctx, models.Notification{
-				Type:    string(ErrorNotification),
-				Message: errorMsg,
-			})
-		}
-
-		if successMsg := r.URL.Query().Get("success"); successMsg != "" {
-			ctx = context.AddNotification(ctx, models.Notification{
-				Type:    string(SuccessNotification),
-				Message: successMsg,
-			})
-		}
+		ctx := stdcontext.WithValue(r.Context(), flashKey{}, m.FlashCookie)
 
-		if infoMsg := r.URL.Query().Get("info"); infoMsg != "" {
-			ctx = context.AddNotification(ctx, models.Notification{
-				Type:    string(InfoNotification),
-				Message: infoMsg,
-			})
+		notifications, err := m.FlashCookie.Get(r)
+		switch {
+		case err == nil:
+			m.FlashCookie.Clear(w)
+			for _, notification := range notifications {
+				ctx = context.AddNotification(ctx, notification)
+			}
+		case !errors.Is(err, http.ErrNoCookie):
+			logging.FromContext(r.Context()).Error("read flash", "error", err)
+			m.FlashCookie.Clear(w)
 		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// flash returns the cookie store set up by NotificationMiddleware.
+func flash(r *http.Request) *FlashCookie {
+	fc, ok := r.Context().Value(flashKey{}).(*FlashCookie)
+	if !ok {
+		return nil
+	}
+	return fc
+}
+
+// addFlash stores a notification for the next page the user sees.
+func addFlash(w http.ResponseWriter, r *http.Request, notificationType NotificationType, message string) {
+	fc := flash(r)
+	if fc == nil {
+		logging.FromContext(r.Context()).Error("add flash: notification middleware is not installed")
+		return
+	}
+
+	err := fc.Set(w, []models.Notification{{Type: string(notificationType), Message: message}})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("add flash", "error", err)
+	}
+}
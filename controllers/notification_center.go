@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// NotificationCenter lists the persistent notifications of the current user. Flash
+// messages shown once after a redirect are handled by NotificationMiddleware instead.
+type NotificationCenter struct {
+	Templates struct {
+		Index Template
+	}
+
+	NotificationService *models.NotificationService
+}
+
+func NewNotificationCenter(ns *models.NotificationService) *NotificationCenter {
+	return &NotificationCenter{
+		NotificationService: ns,
+	}
+}
+
+type notificationPreference struct {
+	Kind  models.NotificationKind
+	Label string
+	Email bool
+}
+
+func (n NotificationCenter) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	notifications, err := n.NotificationService.ByUserID(r.Context(), user.ID, models.DefaultNotificationLimit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("notifications", "error", err)
+		RedirectWithNotification(w, r, "/", ErrorNotification, "Failed to retrieve notifications", nil)
+		return
+	}
+
+	var data struct {
+		Notifications []models.UserNotification
+		// Preferences is empty when every kind is always emailed, the template then
+		// leaves out the email preferences.
+		Preferences []notificationPreference
+	}
+	data.Notifications = notifications
+
+	kinds := models.OptionalNotificationKinds()
+	if len(kinds) == 0 {
+		n.Templates.Index.Execute(w, r, data)
+		return
+	}
+
+	preferences, err := n.NotificationService.EmailPreferences(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("notification preferences", "error", err)
+		RedirectWithNotification(w, r, "/", ErrorNotification, "Failed to retrieve notifications", nil)
+		return
+	}
+	for _, kind := range kinds {
+		data.Preferences = append(data.Preferences, notificationPreference{
+			Kind:  kind,
+			Label: kind.Label(),
+			Email: preferences[kind],
+		})
+	}
+
+	n.Templates.Index.Execute(w, r, data)
+}
+
+func (n NotificationCenter) MarkRead(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Invalid notification ID", nil)
+		return
+	}
+
+	if err = n.NotificationService.MarkRead(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Notification not found", nil)
+			return
+		}
+		logging.FromContext(r.Context()).Error("mark notification read", "error", err)
+		RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+func (n NotificationCenter) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	if err := n.NotificationService.MarkAllRead(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("mark all notifications read", "error", err)
+		RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	RedirectWithNotification(w, r, "/notifications", SuccessNotification, "All notifications marked as read", nil)
+}
+
+// HandleUpdatePreferences stores the email preferences. A kind is emailed when its
+// checkbox is ticked; unticked checkboxes are not submitted at all. It is only routed
+// while there are OptionalNotificationKinds.
+func (n NotificationCenter) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		logging.FromContext(r.Context()).Error("parse form", "error", err)
+		RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	preferences := map[models.NotificationKind]bool{}
+	for _, kind := range models.OptionalNotificationKinds() {
+		preferences[kind] = r.PostForm.Has(string(kind))
+	}
+
+	if err := n.NotificationService.UpdateEmailPreferences(r.Context(), user.ID, preferences); err != nil {
+		logging.FromContext(r.Context()).Error("update notification preferences", "error", err)
+		RedirectWithNotification(w, r, "/notifications", ErrorNotification, "Failed to update email preferences", nil)
+		return
+	}
+
+	RedirectWithNotification(w, r, "/notifications", SuccessNotification, "Email preferences updated", nil)
+}
+
+// SetUnreadCount makes the number of unread notifications available to templates
+// through the unreadNotifications function. It must run after UserMiddleware.SetUser.
+// The notifications are only counted when a template asks for the number, so
+// redirects, downloads and JSON responses cost no query.
+func (n NotificationCenter) SetUnreadCount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithUnreadNotifications(r.Context(), func() int {
+			count, err := n.NotificationService.Unread(r.Context(), user.ID)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("count unread notifications", "error", err)
+			}
+			return count
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// tus 1.0 protocol constants, see https://tus.io/protocols/resumable-upload.
+const (
+	tusVersion      = "1.0.0"
+	tusExtensions   = "creation,termination"
+	tusContentType  = "application/offset+octet-stream"
+	tusChunkTimeout = 10 * time.Minute
+)
+
+// Uploads implements a tus 1.0 server for resumable, chunked image uploads into a gallery.
+type Uploads struct {
+	GalleryService *models.GalleryService
+	UploadService  *models.UploadService
+	AuditService   *models.AuditService
+}
+
+func NewUploads(gs *models.GalleryService, us *models.UploadService, as *models.AuditService) *Uploads {
+	return &Uploads{
+		GalleryService: gs,
+		UploadService:  us,
+		AuditService:   as,
+	}
+}
+
+// Options advertises the protocol version, extensions and size limit.
+func (u Uploads) Options(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(u.UploadService.MaxUploadSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (u Uploads) Create(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := u.ownedGallery(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	filename := parseTusMetadata(r.Header.Get("Upload-Metadata"))["filename"]
+	if filename == "" {
+		http.Error(w, "Missing filename in Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := u.UploadService.Create(gallery.ID, context.User(r.Context()).ID, filename, length)
+	if err != nil {
+		var fileErr models.FileError
+		switch {
+		case errors.Is(err, models.ErrUploadTooLarge):
+			http.Error(w, "Upload exceeds the maximum size", http.StatusRequestEntityTooLarge)
+		case errors.As(err, &fileErr):
+			http.Error(w, fileErr.Error(), http.StatusUnsupportedMediaType)
+		default:
+			logging.FromContext(r.Context()).Error("create upload", "error", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Location", "/galleries/"+strconv.Itoa(gallery.ID)+"/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head reports how much of the upload has been received, so a client can resume.
+func (u Uploads) Head(w http.ResponseWriter, r *http.Request) {
+	upload, ok := u.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u Uploads) Patch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	upload, ok := u.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	// Chunks over slow connections can outlast the server timeouts.
+	rc := http.NewResponseController(w)
+	if err = rc.SetReadDeadline(time.Now().Add(tusChunkTimeout)); err != nil {
+		logging.FromContext(r.Context()).Warn("set read deadline", "error", err)
+	}
+	if err = rc.SetWriteDeadline(time.Now().Add(tusChunkTimeout)); err != nil {
+		logging.FromContext(r.Context()).Warn("set write deadline", "error", err)
+	}
+
+	newOffset, err := u.UploadService.Append(upload, offset, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUploadOffsetMismatch):
+			http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+			return
+		case errors.Is(err, models.ErrUploadLocked):
+			http.Error(w, "Upload is in progress", http.StatusLocked)
+			return
+		case errors.Is(err, models.ErrNotFound):
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		// A broken connection is expected, the client resumes from the stored offset.
+		logging.FromContext(r.Context()).Warn("append upload", "error", err)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+
+	if !upload.Complete() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err = u.UploadService.Finish(r.Context(), upload); err != nil {
+		var fileErr models.FileError
+		switch {
+		case errors.As(err, &fileErr):
+			http.Error(w, fileErr.Error(), http.StatusUnsupportedMediaType)
+			return
+		case errors.Is(err, models.ErrUploadLocked):
+			http.Error(w, "Upload is in progress", http.StatusLocked)
+			return
+		case errors.Is(err, models.ErrNotFound):
+			// Finished by another request.
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("finish upload", "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	gallery, err := u.GalleryService.ByID(r.Context(), upload.GalleryID)
+	if err == nil {
+		recordAudit(u.AuditService, r, models.AuditEvent{
+			OwnerID: &gallery.UserID,
+			Action:  models.AuditImageUpload,
+			Target:  "gallery:" + strconv.Itoa(gallery.ID),
+			Metadata: map[string]string{
+				"filename": upload.Filename,
+				"size":     strconv.FormatInt(upload.Length, 10),
+			},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete terminates an upload and discards the received data.
+func (u Uploads) Delete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := u.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := u.UploadService.Delete(upload.ID); err != nil {
+		if errors.Is(err, models.ErrUploadLocked) {
+			http.Error(w, "Upload is in progress", http.StatusLocked)
+			return
+		}
+		logging.FromContext(r.Context()).Error("delete upload", "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequireTusResumable rejects requests for an unsupported protocol version and adds
+// the Tus-Resumable header to every response. OPTIONS requests are exempt.
+func (u Uploads) RequireTusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (u Uploads) ownedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	gallery, err := u.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if gallery.UserID != context.User(r.Context()).ID {
+		http.Error(w, "You do not have permission to edit this gallery", http.StatusForbidden)
+		return nil, false
+	}
+
+	return gallery, true
+}
+
+func (u Uploads) ownedUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	gallery, ok := u.ownedGallery(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	upload, err := u.UploadService.ByID(chi.URLParam(r, "uploadID"))
+	if err != nil || upload.GalleryID != gallery.ID || upload.UserID != gallery.UserID {
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			logging.FromContext(r.Context()).Error("upload by id", "error", err)
+		}
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, a comma separated list of keys
+// with optional base64 encoded values. Malformed pairs are ignored.
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata
+}
@@ -3,29 +3,35 @@ package controllers
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/azdanov/imago/config"
 	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
 	"github.com/azdanov/imago/models"
+	"github.com/go-chi/chi/v5"
 )
 
-const minPasswordLength = 8
-
 type Users struct {
 	Templates struct {
 		SignUp         Template
 		SignIn         Template
 		ForgotPassword Template
 		ResetPassword  Template
+		Me             Template
 	}
 
 	UserService          *models.UserService
 	SessionService       *models.SessionService
 	PasswordResetService *models.PasswordResetService
 	EmailService         *models.EmailService
+	JobService           *models.JobService
+	AuditService         *models.AuditService
+	AccountDeletion      *models.AccountDeletionService
+	DataExportService    *models.DataExportService
 
 	SessionCookie *SessionCookie
 	serverURL     string
@@ -37,6 +43,10 @@ func NewUsers(
 	sc *SessionCookie,
 	ps *models.PasswordResetService,
 	es *models.EmailService,
+	js *models.JobService,
+	as *models.AuditService,
+	ds *models.AccountDeletionService,
+	xs *models.DataExportService,
 	cnf *config.Config,
 ) *Users {
 	return &Users{
@@ -45,6 +55,10 @@ func NewUsers(
 		SessionCookie:        sc,
 		PasswordResetService: ps,
 		EmailService:         es,
+		JobService:           js,
+		AuditService:         as,
+		AccountDeletion:      ds,
+		DataExportService:    xs,
 		serverURL:            cnf.Server.GetURL(),
 	}
 }
@@ -73,11 +87,8 @@ func (u Users) NewSignup(w http.ResponseWriter, r *http.Request) {
 
 func (u Users) HandleSignup(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		log.Printf("parse form: %v", err)
-		vals := url.Values{
-			models.NotificationError: {"Something went wrong"},
-		}
-		http.Redirect(w, r, "/signup?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("parse form", "error", err)
+		RedirectWithNotification(w, r, "/signup", ErrorNotification, "Something went wrong", nil)
 		return
 	}
 
@@ -89,42 +100,34 @@ func (u Users) HandleSignup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if email == "" {
-		vals.Set(models.NotificationError, "Email is required")
-		http.Redirect(w, r, "/signup?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/signup", ErrorNotification, "Email is required", vals)
 		return
 	}
 	if password == "" {
-		vals.Set(models.NotificationError, "Password is required")
-		http.Redirect(w, r, "/signup?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/signup", ErrorNotification, "Password is required", vals)
 		return
 	}
-	if len(password) < minPasswordLength {
-		vals.Set(models.NotificationError,
-			fmt.Sprintf("Password must be at least %d characters long", minPasswordLength))
-		http.Redirect(w, r, "/signup?"+vals.Encode(), http.StatusSeeOther)
+	if len(password) < models.MinPasswordLength {
+		RedirectWithNotification(w, r, "/signup", ErrorNotification,
+			fmt.Sprintf("Password must be at least %d characters long", models.MinPasswordLength), vals)
 		return
 	}
 
-	user, err := u.UserService.Create(email, password)
+	user, err := u.UserService.Create(r.Context(), email, password)
 	if err != nil {
-		log.Printf("create user: %v", err)
+		logging.FromContext(r.Context()).Error("create user", "error", err)
+		message := "Error creating user"
 		if errors.Is(err, models.ErrEmailAlreadyExists) {
-			vals.Set(models.NotificationError, "Email already exists")
-		} else {
-			vals.Set(models.NotificationError, "Error creating user")
+			message = "Email already exists"
 		}
-		http.Redirect(w, r, "/signup?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/signup", ErrorNotification, message, vals)
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		log.Printf("create session: %v", err)
-		vals = url.Values{
-			"email":                  {email},
-			models.NotificationError: {"Error creating session"},
-		}
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("create session", "error", err)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Error creating session", vals)
 		return
 	}
 
@@ -143,11 +146,8 @@ func (u Users) NewSignin(w http.ResponseWriter, r *http.Request) {
 
 func (u Users) HandleSignin(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		log.Printf("parse form: %v", err)
-		vals := url.Values{
-			models.NotificationError: {"Something went wrong"},
-		}
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("parse form", "error", err)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Something went wrong", nil)
 		return
 	}
 
@@ -159,32 +159,50 @@ func (u Users) HandleSignin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if email == "" {
-		vals.Set(models.NotificationError, "Email is required")
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Email is required", vals)
 		return
 	}
 	if password == "" {
-		vals.Set(models.NotificationError, "Password is required")
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Password is required", vals)
 		return
 	}
 
-	user, err := u.UserService.Authenticate(email, password)
+	user, err := u.UserService.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Printf("authenticate user: %v", err)
-		vals.Set(models.NotificationError, "Invalid email or password")
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("authenticate user", "error", err)
+		reason, message := "invalid_credentials", "Invalid email or password"
+		if errors.Is(err, models.ErrAccountDisabled) {
+			reason, message = "account_disabled", "This account has been disabled"
+		}
+		event := models.AuditEvent{
+			Action:   models.AuditSignInFailed,
+			Target:   models.AuditEmailTarget(email),
+			Metadata: map[string]string{"reason": reason},
+		}
+		// Attempts on an existing account are shown to its owner.
+		if owner, err := u.UserService.ByEmail(r.Context(), email); err == nil {
+			event.OwnerID = &owner.ID
+			event.Target = "user:" + strconv.Itoa(owner.ID)
+		}
+		recordAudit(u.AuditService, r, event)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, message, vals)
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		log.Printf("create session: %v", err)
-		vals.Set(models.NotificationError, "Error creating session")
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("create session", "error", err)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Error creating session", vals)
 		return
 	}
 
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		ActorID: &user.ID,
+		OwnerID: &user.ID,
+		Action:  models.AuditSignIn,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
 	u.SessionCookie.Set(w, session.Token)
 	http.Redirect(w, r, "/users/me", http.StatusSeeOther)
 }
@@ -192,20 +210,25 @@ func (u Users) HandleSignin(w http.ResponseWriter, r *http.Request) {
 func (u Users) HandleSignout(w http.ResponseWriter, r *http.Request) {
 	token, err := u.SessionCookie.Get(r)
 	if err != nil {
-		log.Printf("get token: %v", err)
+		logging.FromContext(r.Context()).Error("get token", "error", err)
 		http.Redirect(w, r, "/signin", http.StatusSeeOther)
 		return
 	}
 
-	if err = u.SessionService.Delete(token); err != nil {
-		log.Printf("delete session: %v", err)
-		vals := url.Values{
-			models.NotificationError: {"Error signing out"},
-		}
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+	if err = u.SessionService.Delete(r.Context(), token); err != nil {
+		logging.FromContext(r.Context()).Error("delete session", "error", err)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Error signing out", nil)
 		return
 	}
 
+	if user := context.User(r.Context()); user != nil {
+		recordAudit(u.AuditService, r, models.AuditEvent{
+			OwnerID: &user.ID,
+			Action:  models.AuditSignOut,
+			Target:  "user:" + strconv.Itoa(user.ID),
+		})
+	}
+
 	u.SessionCookie.Clear(w)
 	http.Redirect(w, r, "/signin", http.StatusSeeOther)
 }
@@ -227,29 +250,35 @@ func (u Users) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
 		"email": {email},
 	}
 
-	passwordReset, err := u.PasswordResetService.Generate(email)
-	if err != nil {
-		log.Printf("generate password reset: %v", err)
-		vals.Set(models.NotificationError, "Something went wrong")
-		http.Redirect(w, r, "/forgot-password?"+vals.Encode(), http.StatusSeeOther)
-		return
-	}
-
-	resetVals := url.Values{
-		"token": {passwordReset.Token},
+	message := func(reset *models.PasswordReset) (models.OutboxMessage, error) {
+		resetURL := passwordResetURL(u.serverURL, reset.Token)
+		resetEmail, err := u.EmailService.ResetPasswordEmail(email, reset.Locale, resetURL)
+		if err != nil {
+			return models.OutboxMessage{}, err
+		}
+		return u.EmailService.Message(resetEmail), nil
 	}
-	resetURL := u.serverURL + "?" + resetVals.Encode()
-
-	err = u.EmailService.SendResetPassword(email, resetURL)
+	passwordReset, err := u.PasswordResetService.Generate(r.Context(), email, message)
 	if err != nil {
-		log.Printf("send email: %v", err)
-		vals.Set(models.NotificationError, "Something went wrong")
-		http.Redirect(w, r, "/forgot-password?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("generate password reset", "error", err)
+		RedirectWithNotification(w, r, "/forgot-password", ErrorNotification, "Something went wrong", vals)
 		return
 	}
 
-	vals.Set(models.NotificationSuccess, "An email has been sent with instructions to reset your password")
-	http.Redirect(w, r, "/forgot-password?"+vals.Encode(), http.StatusSeeOther)
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		OwnerID: &passwordReset.UserID,
+		Action:  models.AuditPasswordResetRequest,
+		Target:  "user:" + strconv.Itoa(passwordReset.UserID),
+	})
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/forgot-password",
+		SuccessNotification,
+		"An email has been sent with instructions to reset your password",
+		vals,
+	)
 }
 
 func (u Users) NewResetPassword(w http.ResponseWriter, r *http.Request) {
@@ -264,11 +293,8 @@ func (u Users) NewResetPassword(w http.ResponseWriter, r *http.Request) {
 
 func (u Users) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		log.Printf("parse form: %v", err)
-		vals := url.Values{
-			models.NotificationError: {"Something went wrong"},
-		}
-		http.Redirect(w, r, "/reset-password?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("parse form", "error", err)
+		RedirectWithNotification(w, r, "/reset-password", ErrorNotification, "Something went wrong", nil)
 		return
 	}
 	token := r.PostForm.Get("token")
@@ -278,29 +304,31 @@ func (u Users) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
 		"token": {token},
 	}
 
-	user, err := u.PasswordResetService.GetUserByToken(token)
+	user, err := u.PasswordResetService.GetUserByToken(r.Context(), token)
 	if err != nil {
-		log.Printf("get user by token: %v", err)
-		vals.Set(models.NotificationError, "Invalid or expired token")
-		http.Redirect(w, r, "/reset-password?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("get user by token", "error", err)
+		RedirectWithNotification(w, r, "/reset-password", ErrorNotification, "Invalid or expired token", vals)
 		return
 	}
 
-	if err = u.UserService.UpdatePassword(user.ID, password); err != nil {
-		log.Printf("update password: %v", err)
-		vals.Set(models.NotificationError, "Internal server error")
-		http.Redirect(w, r, "/reset-password?"+vals.Encode(), http.StatusSeeOther)
+	if err = u.UserService.UpdatePassword(r.Context(), user.ID, password); err != nil {
+		logging.FromContext(r.Context()).Error("update password", "error", err)
+		RedirectWithNotification(w, r, "/reset-password", ErrorNotification, "Internal server error", vals)
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		ActorID: &user.ID,
+		OwnerID: &user.ID,
+		Action:  models.AuditPasswordReset,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		log.Printf("create session: %v", err)
-		vals = url.Values{
-			models.NotificationError: {"Error creating session. Please try again"},
-			"email":                  {user.Email},
-		}
-		http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+		logging.FromContext(r.Context()).Error("create session", "error", err)
+		RedirectWithNotification(w, r, "/signin", ErrorNotification, "Error creating session. Please try again",
+			url.Values{"email": {user.Email}})
 		return
 	}
 
@@ -309,6 +337,197 @@ func (u Users) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/users/me", http.StatusSeeOther)
 }
 
+func (u Users) CurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	events, err := u.AuditService.ByUserID(r.Context(), user.ID, models.DefaultAuditLimit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("audit events", "error", err)
+		RedirectWithNotification(w, r, "/", ErrorNotification, "Failed to retrieve account activity", nil)
+		return
+	}
+
+	var data struct {
+		AuditEvents  []models.AuditEvent
+		DeletionDate time.Time
+		Locales      map[string]string
+	}
+	data.AuditEvents = events
+	data.Locales = models.Locales
+	if user.IsPendingDeletion() {
+		data.DeletionDate = u.AccountDeletion.PurgeAfter(*user.DeletionRequestedAt)
+	}
+
+	u.Templates.Me.Execute(w, r, data)
+}
+
+func (u Users) HandleUpdateLocale(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	if err := u.UserService.UpdateLocale(r.Context(), user.ID, r.FormValue("locale")); err != nil {
+		if !errors.Is(err, models.ErrUnsupportedLocale) {
+			logging.FromContext(r.Context()).Error("update locale", "error", err)
+		}
+		RedirectWithNotification(w, r, "/users/me", ErrorNotification, "Failed to update email language", nil)
+		return
+	}
+
+	RedirectWithNotification(w, r, "/users/me", SuccessNotification, "Email language updated", nil)
+}
+
+func (u Users) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	if _, err := u.UserService.Authenticate(r.Context(), user.Email, r.FormValue("password")); err != nil {
+		logging.FromContext(r.Context()).Error("confirm password", "error", err)
+		RedirectWithNotification(
+			w,
+			r,
+			"/users/me",
+			ErrorNotification,
+			"Incorrect password. Your account was not deleted",
+			nil,
+		)
+		return
+	}
+
+	deletionDate, err := u.AccountDeletion.Schedule(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("schedule deletion", "error", err)
+		RedirectWithNotification(w, r, "/users/me", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		OwnerID:  &user.ID,
+		Action:   models.AuditAccountDeleteRequest,
+		Target:   "user:" + strconv.Itoa(user.ID),
+		Metadata: map[string]string{"purge_after": deletionDate.Format(time.RFC3339)},
+	})
+
+	u.SessionCookie.Clear(w)
+	RedirectWithNotification(w, r, "/signin", InfoNotification, fmt.Sprintf(
+		"Your account will be deleted on %s. To keep it, sign in before then and choose Keep my account.",
+		deletionDate.Format("January 2, 2006")), nil)
+}
+
+func (u Users) HandleCancelDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	if err := u.AccountDeletion.Cancel(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("cancel deletion", "error", err)
+		RedirectWithNotification(w, r, "/users/me", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditAccountDeleteCancel,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/users/me",
+		SuccessNotification,
+		"Your account is no longer scheduled for deletion",
+		nil,
+	)
+}
+
+func (u Users) HandleExportData(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	export, err := u.DataExportService.Create(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("create export", "error", err)
+		RedirectWithNotification(w, r, "/users/me", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditDataExportRequest,
+		Target:  "export:" + strconv.Itoa(export.ID),
+	})
+
+	_, err = u.JobService.Enqueue(r.Context(), models.JobBuildDataExport, models.BuildDataExportJob{
+		ExportID: export.ID,
+		Email:    user.Email,
+		Locale:   user.Locale,
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("enqueue export", "error", err)
+		RedirectWithNotification(w, r, "/users/me", ErrorNotification, "Something went wrong", nil)
+		return
+	}
+
+	RedirectWithNotification(
+		w,
+		r,
+		"/users/me",
+		InfoNotification,
+		"We are preparing your data. You will receive an email with a download link shortly.",
+		nil,
+	)
+}
+
+func (u Users) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	export, err := u.DataExportService.ByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil || export.UserID != user.ID {
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			logging.FromContext(r.Context()).Error("export by token", "error", err)
+		}
+		RedirectWithNotification(
+			w,
+			r,
+			"/users/me",
+			ErrorNotification,
+			"This download link is invalid or has expired",
+			nil,
+		)
+		return
+	}
+
+	recordAudit(u.AuditService, r, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditDataExportDownload,
+		Target:  "export:" + strconv.Itoa(export.ID),
+	})
+
+	w.Header().Set("Content-Disposition", `attachment; filename="imago-export.zip"`)
+	http.ServeFile(w, r, export.Path)
+}
+
+// passwordResetURL returns the link sent in password reset emails. It points at the
+// reset form, older emails linked to the home page instead, see ResetLinkRedirect.
+func passwordResetURL(serverURL, token string) string {
+	vals := url.Values{
+		"token": {token},
+	}
+	return serverURL + "/reset-password?" + vals.Encode()
+}
+
+// ResetLinkRedirect sends the links of password reset emails sent before they
+// pointed at the reset form, like /?token=..., on to /reset-password with the same
+// token. Other requests are served by next.
+func ResetLinkRedirect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		vals := url.Values{
+			"token": {token},
+		}
+		http.Redirect(w, r, "/reset-password?"+vals.Encode(), http.StatusMovedPermanently)
+	})
+}
+
 func (m UserMiddleware) SetUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, err := m.SessionCookie.Get(r)
@@ -317,12 +536,13 @@ func (m UserMiddleware) SetUser(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := m.SessionService.User(token)
+		user, err := m.SessionService.User(r.Context(), token)
 		if err != nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		logging.With(r.Context(), "user_id", user.ID)
 		ctx := context.WithUser(r.Context(), user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -333,10 +553,34 @@ func (m UserMiddleware) RequireUser(next http.Handler) http.Handler {
 		user := context.User(r.Context())
 		if user == nil {
 			path := r.URL.Path
-			vals := url.Values{
-				models.NotificationError: {fmt.Sprintf("You must be signed in to access \"%s\" page", path)},
-			}
-			http.Redirect(w, r, "/signin?"+vals.Encode(), http.StatusSeeOther)
+			RedirectWithNotification(
+				w,
+				r,
+				"/signin",
+				ErrorNotification,
+				fmt.Sprintf("You must be signed in to access \"%s\" page", path),
+				nil,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin must be used after RequireUser, as it expects a user in the context.
+func (m UserMiddleware) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil || !user.IsAdmin() {
+			RedirectWithNotification(
+				w,
+				r,
+				"/",
+				ErrorNotification,
+				"You do not have permission to access this page",
+				nil,
+			)
 			return
 		}
 
@@ -0,0 +1,179 @@
+package controllers_test
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/templates"
+)
+
+// newUsers returns a Users controller backed by in-memory stores, and the transport
+// receiving its emails once they are dispatched.
+func newUsers(t *testing.T) (*controllers.Users, *controllers.UserMiddleware, *models.MemoryTransport) {
+	t.Helper()
+
+	emailsFS, err := fs.Sub(templates.FS, "emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailTemplates, err := models.ParseEmailTemplates(emailsFS)
+	if err != nil {
+		t.Fatalf("ParseEmailTemplates() error = %v", err)
+	}
+	transport := &models.MemoryTransport{}
+	outbox := models.NewMemoryEmailOutbox()
+
+	userStore := models.NewMemoryUserStore()
+	us := models.NewUserService(userStore)
+	ss := models.NewSessionService(models.NewMemorySessionStore(userStore), models.MinSessionTokenBytes)
+	ps := models.NewPasswordResetService(models.NewMemoryPasswordResetStore(outbox), userStore,
+		models.MinSessionTokenBytes, models.DefaultTokenLifetime)
+	es := &models.EmailService{Outbox: outbox, Transport: transport, Templates: emailTemplates}
+	sc := controllers.NewSessionCookie(false)
+
+	cnf := &config.Config{Server: config.ServerConfig{Host: "localhost", Port: 3000}}
+	users := controllers.NewUsers(us, ss, sc, ps, es, nil, nil, nil, nil, cnf)
+
+	return users, controllers.NewUserMiddleware(ss, sc), transport
+}
+
+func postForm(handler http.HandlerFunc, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == controllers.SessionName && cookie.Value != "" {
+			return cookie
+		}
+	}
+	t.Fatalf("no %s cookie set, status %d, location %q", controllers.SessionName, w.Code, w.Header().Get("Location"))
+
+	return nil
+}
+
+// signedInEmail returns the email of the user the middleware finds for the cookie.
+func signedInEmail(umw *controllers.UserMiddleware, cookie *http.Cookie) string {
+	var email string
+	handler := umw.SetUser(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if user := context.User(r.Context()); user != nil {
+			email = user.Email
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+	r.AddCookie(cookie)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	return email
+}
+
+func TestUsersSignupAndSignin(t *testing.T) {
+	users, umw, _ := newUsers(t)
+	form := url.Values{"email": {"ada@example.com"}, "password": {"correct horse"}}
+
+	w := postForm(users.HandleSignup, form)
+	if location := w.Header().Get("Location"); w.Code != http.StatusSeeOther || location != "/users/me" {
+		t.Fatalf("HandleSignup() = %d %q, want redirect to /users/me", w.Code, location)
+	}
+	if got := signedInEmail(umw, sessionCookie(t, w)); got != "ada@example.com" {
+		t.Errorf("signed in as %q after sign up, want ada@example.com", got)
+	}
+
+	w = postForm(users.HandleSignup, form)
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "/signup") {
+		t.Errorf("HandleSignup() with taken email redirects to %q, want /signup", location)
+	}
+
+	w = postForm(users.HandleSignin, url.Values{"email": {"ada@example.com"}, "password": {"wrong password"}})
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "/signin") {
+		t.Errorf("HandleSignin() with wrong password redirects to %q, want /signin", location)
+	}
+
+	w = postForm(users.HandleSignin, form)
+	if got := signedInEmail(umw, sessionCookie(t, w)); got != "ada@example.com" {
+		t.Errorf("signed in as %q after sign in, want ada@example.com", got)
+	}
+}
+
+var resetTokenPattern = regexp.MustCompile(`reset-password\?token=([^\s"&]+)`)
+
+func TestUsersResetPassword(t *testing.T) {
+	users, umw, transport := newUsers(t)
+	postForm(users.HandleSignup, url.Values{"email": {"ada@example.com"}, "password": {"correct horse"}})
+
+	postForm(users.HandleForgotPassword, url.Values{"email": {"ada@example.com"}})
+	if _, err := users.EmailService.Dispatch(t.Context(), models.DefaultEmailBatchSize); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	email, ok := transport.Last("ada@example.com")
+	if !ok {
+		t.Fatal("no reset email sent")
+	}
+	match := resetTokenPattern.FindStringSubmatch(email.Plaintext)
+	if match == nil {
+		t.Fatalf("reset email has no reset link:\n%s", email.Plaintext)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"token": {token}, "password": {"battery staple"}}
+	w := postForm(users.HandleResetPassword, form)
+	if got := signedInEmail(umw, sessionCookie(t, w)); got != "ada@example.com" {
+		t.Errorf("signed in as %q after reset, want ada@example.com", got)
+	}
+
+	w = postForm(users.HandleResetPassword, form)
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "/reset-password") {
+		t.Errorf("HandleResetPassword() with used token redirects to %q, want /reset-password", location)
+	}
+
+	w = postForm(users.HandleSignin, url.Values{"email": {"ada@example.com"}, "password": {"battery staple"}})
+	if got := signedInEmail(umw, sessionCookie(t, w)); got != "ada@example.com" {
+		t.Errorf("signed in as %q with new password, want ada@example.com", got)
+	}
+}
+
+func TestResetLinkRedirect(t *testing.T) {
+	home := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := controllers.ResetLinkRedirect(home)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?token=abc%2B1", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("old reset link: code = %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+	if location, want := w.Header().Get("Location"), "/reset-password?token=abc%2B1"; location != want {
+		t.Errorf("old reset link redirects to %q, want %q", location, want)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("home page: code = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -4,11 +4,22 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/XSAM/otelsql"
 	"github.com/azdanov/imago/config"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the pgx driver
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+// NewDB opens the connection pool. Every query is recorded as a span, nested under
+// the request or job span when the caller passes its context.
 func NewDB(cnf *config.Config) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cnf.DB.GetDSN())
+	db, err := otelsql.Open("pgx", cnf.DB.GetDSN(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("database: %w", err)
 	}
@@ -1,23 +1,70 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"embed"
 	"fmt"
+	"io/fs"
+	"log/slog"
 
 	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
 )
 
-func Migrate(db *sql.DB, fs embed.FS, dir string) error {
-	goose.SetBaseFS(fs)
-
-	if err := goose.SetDialect("postgres"); err != nil {
+// Migrate applies the pending migrations in dir to db. The migrations hold a Postgres
+// advisory lock while they run, so when several instances start at once only one of
+// them migrates and the others wait for it.
+func Migrate(ctx context.Context, db *sql.DB, fsys fs.FS, dir string) error {
+	migrations, err := NewMigrations(db, fsys, dir)
+	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
 
-	if err := goose.Up(db, dir); err != nil {
+	results, err := migrations.Up(ctx)
+	for _, result := range results {
+		slog.InfoContext(ctx, "Applied migration", "migration", result.Source.Path, "duration", result.Duration)
+	}
+	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
 
 	return nil
 }
+
+// NewMigrations returns a provider for applying the migrations in dir to db, one at a
+// time or all at once, and for reporting their status. Only one provider at a time
+// applies migrations to a database, the others wait for its advisory lock.
+func NewMigrations(db *sql.DB, fsys fs.FS, dir string) (*goose.Provider, error) {
+	migrations, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: %w", err)
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("migrations: %w", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("migrations: %w", err)
+	}
+
+	return provider, nil
+}
+
+// Versions returns the schema version of db and the latest version of the migrations
+// in dir. The database is up to date when both are equal.
+func Versions(ctx context.Context, db *sql.DB, fsys fs.FS, dir string) (current, latest int64, err error) {
+	provider, err := NewMigrations(db, fsys, dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("migration versions: %w", err)
+	}
+
+	current, latest, err = provider.GetVersions(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("migration versions: %w", err)
+	}
+
+	return current, latest, nil
+}
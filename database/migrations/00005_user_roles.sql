@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN role TEXT NOT NULL DEFAULT 'user',
+ADD COLUMN disabled_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN role,
+DROP COLUMN disabled_at;
+-- +goose StatementEnd
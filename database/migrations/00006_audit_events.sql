@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id INTEGER,
+	owner_id INTEGER,
+	ip TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	metadata JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX audit_events_owner_id_idx ON audit_events (owner_id);
+-- +goose StatementEnd
+
+-- The audit log is append-only, so updates and deletes are rejected outright.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE data_exports (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT UNIQUE NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	path TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE data_exports;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE jobs (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	status TEXT NOT NULL DEFAULT 'queued',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	unique_key TEXT,
+	last_error TEXT NOT NULL DEFAULT '',
+	run_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+	locked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX jobs_status_run_at_idx ON jobs (status, run_at);
+
+-- At most one pending job per unique key, used for recurring jobs.
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+	WHERE status IN ('queued', 'running');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE jobs;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE email_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	sender TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	plaintext TEXT NOT NULL DEFAULT '',
+	html TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+	sent_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX email_outbox_status_next_attempt_at_idx ON email_outbox (status, next_attempt_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE email_outbox;
+-- +goose StatementEnd
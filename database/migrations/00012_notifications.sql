@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE notifications (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	link TEXT NOT NULL DEFAULT '',
+	read_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX notifications_user_id_idx ON notifications (user_id, created_at DESC);
+CREATE INDEX notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
+
+CREATE TABLE notification_preferences (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	email BOOLEAN NOT NULL,
+	PRIMARY KEY (user_id, kind)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
+-- +goose StatementEnd
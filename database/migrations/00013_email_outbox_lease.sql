@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE email_outbox ADD COLUMN claimed_until TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE email_outbox SET status = 'pending' WHERE status = 'sending';
+ALTER TABLE email_outbox DROP COLUMN claimed_until;
+-- +goose StatementEnd
@@ -0,0 +1,77 @@
+-- +goose Up
+-- The audit log stays append-only for the application, only the functions below may
+-- change it. They set a transaction-local flag the trigger lets through. This guards
+-- against mistakes, not against the table owner, who can always drop the trigger.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	IF current_setting('imago.audit_maintenance', true) = 'on' THEN
+		IF TG_OP = 'DELETE' THEN
+			RETURN OLD;
+		END IF;
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- audit_events_delete_before removes the events older than cutoff, for the
+-- retention job, and returns how many were removed.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_delete_before(cutoff TIMESTAMPTZ) RETURNS BIGINT
+SECURITY DEFINER SET search_path = pg_catalog, public AS $$
+DECLARE
+	deleted BIGINT;
+BEGIN
+	PERFORM set_config('imago.audit_maintenance', 'on', true);
+	DELETE FROM audit_events WHERE created_at < cutoff;
+	GET DIAGNOSTICS deleted = ROW_COUNT;
+	PERFORM set_config('imago.audit_maintenance', 'off', true);
+	RETURN deleted;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- audit_events_anonymize clears the IP, target and metadata of the events of a
+-- user, for when the account is purged. The actions and their times are kept.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_anonymize(purged_user_id INTEGER) RETURNS VOID
+SECURITY DEFINER SET search_path = pg_catalog, public AS $$
+BEGIN
+	PERFORM set_config('imago.audit_maintenance', 'on', true);
+	UPDATE audit_events SET ip = '', target = '', metadata = '{}'
+	WHERE actor_id = purged_user_id OR owner_id = purged_user_id;
+	PERFORM set_config('imago.audit_maintenance', 'off', true);
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Failed sign-ins used to name the email that was tried. Link them to the account
+-- instead, or keep only a hash when there is none.
+-- +goose StatementBegin
+DO $$
+BEGIN
+	PERFORM set_config('imago.audit_maintenance', 'on', true);
+	UPDATE audit_events a
+	SET owner_id = u.id, target = 'user:' || u.id
+	FROM users u
+	WHERE a.action = 'user.signin_failed' AND a.target = 'user:' || u.email;
+	UPDATE audit_events
+	SET target = 'email:' || encode(sha256(convert_to(substr(target, 6), 'UTF8')), 'hex')
+	WHERE action = 'user.signin_failed' AND target LIKE 'user:%' AND target !~ '^user:[0-9]+$';
+	PERFORM set_config('imago.audit_maintenance', 'off', true);
+END;
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP FUNCTION audit_events_anonymize;
+DROP FUNCTION audit_events_delete_before;
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
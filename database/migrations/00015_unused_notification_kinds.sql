@@ -0,0 +1,8 @@
+-- +goose Up
+-- Comment and share notifications were never published, their kinds are gone.
+-- +goose StatementBegin
+DELETE FROM notification_preferences WHERE kind IN ('gallery.comment', 'gallery.share');
+-- +goose StatementEnd
+
+-- +goose Down
+-- The deleted preferences cannot be restored.
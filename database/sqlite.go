@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"fmt"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	_ "modernc.org/sqlite" // registers the sqlite driver
+)
+
+//go:embed sqlite.sql
+var sqliteSchema string
+
+// NewSQLite opens a SQLite database at path and creates the tables used by the SQLite
+// stores. Pass ":memory:" for a database that is gone when it is closed, as in tests.
+// The pool holds a single connection, every connection to ":memory:" would open a
+// separate database otherwise. Queries are recorded as spans like those of NewDB.
+func NewSQLite(path string) (*sql.DB, error) {
+	db, err := otelsql.Open("sqlite", path+"?_pragma=foreign_keys(1)",
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+
+	return db, nil
+}
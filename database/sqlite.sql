@@ -0,0 +1,69 @@
+-- The tables of the Postgres migrations that have a SQLite store in the models
+-- package, see models.NewSQLiteUserStore, or whose service runs on SQLite too, like
+-- models.NotificationService. Keep in sync with the migrations.
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	locale TEXT NOT NULL DEFAULT 'en',
+	disabled_at DATETIME,
+	deletion_requested_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE sessions (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT UNIQUE NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE reset_tokens (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT UNIQUE NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE galleries (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	title TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE email_outbox (
+	id INTEGER PRIMARY KEY,
+	sender TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	plaintext TEXT NOT NULL DEFAULT '',
+	html TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	claimed_until DATETIME,
+	sent_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE notifications (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	link TEXT NOT NULL DEFAULT '',
+	read_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE notification_preferences (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	email BOOLEAN NOT NULL,
+	PRIMARY KEY (user_id, kind)
+);
@@ -0,0 +1,155 @@
+package e2e
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// csrfFieldPattern matches the hidden input rendered by csrf.TemplateField.
+var csrfFieldPattern = regexp.MustCompile(`name="gorilla\.csrf\.Token" value="([^"]+)"`)
+
+// Client is a browser session: it keeps cookies, follows redirects and sends the
+// CSRF token of the last page it loaded with every form.
+type Client struct {
+	t      testing.TB
+	server *Server
+	http   *http.Client
+	token  string
+}
+
+// Response is a page received by a Client, after following redirects.
+type Response struct {
+	StatusCode int
+	// URL is where the redirects ended.
+	URL    *url.URL
+	Header http.Header
+	Body   string
+}
+
+// Client returns a new client without cookies, like a fresh browser.
+func (s *Server) Client(t testing.TB) *Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Client{
+		t:      t,
+		server: s,
+		http:   &http.Client{Jar: jar},
+	}
+}
+
+// Get requests path, like following a link.
+func (c *Client) Get(path string) *Response {
+	c.t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, c.server.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return c.do(req)
+}
+
+// PostForm submits form to path, like a browser submitting an HTML form.
+func (c *Client) PostForm(path string, form url.Values) *Response {
+	c.t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.submit(req)
+}
+
+// PostFile uploads a file in the multipart form field to path.
+func (c *Client) PostFile(path, field, filename string, contents []byte) *Response {
+	c.t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err = part.Write(contents); err != nil {
+		c.t.Fatal(err)
+	}
+	if err = mw.Close(); err != nil {
+		c.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return c.submit(req)
+}
+
+// SignIn signs the client in through the sign in form.
+func (c *Client) SignIn(email, password string) *Response {
+	c.t.Helper()
+
+	res := c.PostForm("/signin", url.Values{"email": {email}, "password": {password}})
+	if res.URL.Path != "/users/me" {
+		c.t.Fatalf("sign in as %s ended on %s, want /users/me", email, res.URL)
+	}
+
+	return res
+}
+
+// submit sends a request that changes state. Browsers send the Origin with form
+// posts, and the CSRF token is sent as a header, so it works for every encoding.
+func (c *Client) submit(req *http.Request) *Response {
+	c.t.Helper()
+
+	if c.token == "" {
+		// Any page with a form hands out a token.
+		c.Get("/signin")
+	}
+	req.Header.Set("Origin", c.server.URL)
+	req.Header.Set("X-CSRF-Token", c.token)
+
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) *Response {
+	c.t.Helper()
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatalf("%s %s: read body: %v", req.Method, req.URL.Path, err)
+	}
+
+	if match := csrfFieldPattern.FindSubmatch(body); match != nil {
+		c.token = html.UnescapeString(string(match[1]))
+	}
+
+	return &Response{
+		StatusCode: res.StatusCode,
+		URL:        res.Request.URL,
+		Header:     res.Header,
+		Body:       string(body),
+	}
+}
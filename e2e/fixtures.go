@@ -0,0 +1,64 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/azdanov/imago/models"
+)
+
+// Password is the password of every user created by CreateUser.
+const Password = "correct horse battery"
+
+// CreateUser adds a user who can sign in with Password.
+func (s *Server) CreateUser(t testing.TB, email string) *models.User {
+	t.Helper()
+
+	user, err := s.Services.UserService.Create(context.Background(), email, Password)
+	if err != nil {
+		t.Fatalf("create user %s: %v", email, err)
+	}
+
+	return user
+}
+
+// CreateGallery adds a gallery owned by user.
+func (s *Server) CreateGallery(t testing.TB, user *models.User, title string) *models.Gallery {
+	t.Helper()
+
+	gallery, err := s.Services.GalleryService.Create(context.Background(), title, user.ID)
+	if err != nil {
+		t.Fatalf("create gallery %q: %v", title, err)
+	}
+
+	return gallery
+}
+
+// CreateImage stores contents as an image of the gallery, see PNG.
+func (s *Server) CreateImage(t testing.TB, gallery *models.Gallery, filename string, contents []byte) {
+	t.Helper()
+
+	err := s.Services.GalleryService.CreateImage(context.Background(), gallery.ID, filename, bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("create image %s: %v", filename, err)
+	}
+}
+
+// PNG returns a small image that passes the upload content checks.
+func PNG(t testing.TB) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
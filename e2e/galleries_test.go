@@ -0,0 +1,93 @@
+package e2e_test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/azdanov/imago/e2e"
+)
+
+func TestGalleryCRUD(t *testing.T) {
+	s := e2e.NewServer(t)
+	s.CreateUser(t, "ada@example.com")
+	c := s.Client(t)
+	c.SignIn("ada@example.com", e2e.Password)
+
+	res := c.PostForm("/galleries", url.Values{"title": {"Holidays"}})
+	path, found := strings.CutSuffix(res.URL.Path, "/edit")
+	if !found {
+		t.Fatalf("create gallery ended on %s, want its edit page", res.URL)
+	}
+
+	if res = c.Get("/galleries"); !strings.Contains(res.Body, "Holidays") {
+		t.Errorf("gallery list does not show the new gallery")
+	}
+
+	c.PostForm(path, url.Values{"title": {"Summer"}})
+	if res = c.Get(path); res.URL.Path != path || !strings.Contains(res.Body, "Summer") {
+		t.Errorf("gallery page %s does not show the new title", res.URL)
+	}
+
+	other := s.Client(t)
+	s.CreateUser(t, "grace@example.com")
+	other.SignIn("grace@example.com", e2e.Password)
+	if res = other.PostForm(path+"/delete", nil); !strings.Contains(res.Body, "You do not have permission") {
+		t.Errorf("deleting another user's gallery ended on %s without an error", res.URL)
+	}
+
+	if res = c.PostForm(path+"/delete", nil); res.URL.Path != "/galleries" {
+		t.Errorf("delete gallery ended on %s, want /galleries", res.URL)
+	}
+	if res = c.Get(path); !strings.Contains(res.Body, "Gallery not found") {
+		t.Errorf("deleted gallery %s is still shown", path)
+	}
+}
+
+func TestImageUpload(t *testing.T) {
+	s := e2e.NewServer(t)
+	ada := s.CreateUser(t, "ada@example.com")
+	gallery := s.CreateGallery(t, ada, "Holidays")
+	path := "/galleries/" + strconv.Itoa(gallery.ID)
+	c := s.Client(t)
+	c.SignIn("ada@example.com", e2e.Password)
+
+	image := e2e.PNG(t)
+	res := c.PostFile(path+"/images", "images", "beach.png", image)
+	if !strings.Contains(res.Body, "Image uploaded successfully") {
+		t.Fatalf("upload ended on %s without a confirmation", res.URL)
+	}
+
+	res = c.PostFile(path+"/images", "images", "notes.png", []byte("not an image"))
+	if !strings.Contains(res.Body, "invalid content type or extension") {
+		t.Errorf("uploading text as an image ended on %s without an error", res.URL)
+	}
+
+	if res = c.Get(path); !strings.Contains(res.Body, path+"/images/beach.png") {
+		t.Errorf("gallery page does not show the uploaded image")
+	}
+}
+
+func TestImageServing(t *testing.T) {
+	s := e2e.NewServer(t)
+	ada := s.CreateUser(t, "ada@example.com")
+	gallery := s.CreateGallery(t, ada, "Holidays")
+	image := e2e.PNG(t)
+	s.CreateImage(t, gallery, "beach.png", image)
+	path := "/galleries/" + strconv.Itoa(gallery.ID) + "/images/"
+
+	// Images are public, like the gallery page.
+	res := s.Client(t).Get(path + "beach.png")
+	if res.StatusCode != http.StatusOK || res.Body != string(image) {
+		t.Fatalf("GET %s = %d with %d bytes, want the image", path+"beach.png", res.StatusCode, len(res.Body))
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", contentType)
+	}
+
+	if res = s.Client(t).Get(path + "missing.png"); res.Body == string(image) {
+		t.Errorf("GET %s served an image", path+"missing.png")
+	}
+}
@@ -0,0 +1,146 @@
+// Package e2e runs the complete application for end-to-end tests. Every Server gets
+// its own freshly migrated Postgres database and image directory, so tests never see
+// each other's data. The database server is configured with the usual DB_*
+// environment variables, tests are skipped when it cannot be reached unless E2E=1 is
+// set, as in CI, where they fail instead.
+package e2e
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/rand"
+	"github.com/azdanov/imago/server"
+)
+
+// Server is the application listening on a local port.
+type Server struct {
+	// URL is the base URL of the server, like http://127.0.0.1:41234.
+	URL      string
+	Config   *config.Config
+	DB       *sql.DB
+	Services *server.Services
+	// Emails receives the emails sent by the server, see Server.LastEmail.
+	Emails *models.MemoryTransport
+}
+
+// NewServer starts the application on a new database. Everything is removed when
+// the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	cnf, err := config.Load(nil, nil)
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cnf.SMTP.Transport = config.SMTPTransportMemory
+	cnf.Events.Backend = config.EventBackendMemory
+	cnf.CSRF.Secure = false
+	cnf.Flash.Secure = false
+	cnf.Server.SSLMode = false
+	cnf.Health.Token = ""
+
+	db := NewDatabase(t, cnf)
+
+	// The router trusts the address it is served on for CSRF checks, so the listener
+	// is opened before the router is built.
+	srv := httptest.NewUnstartedServer(nil)
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnf.Server.Host = host
+	cnf.Server.Port, err = strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := server.NewServices(db, cnf)
+	if err != nil {
+		t.Fatalf("NewServices() error = %v", err)
+	}
+	services.GalleryService.ImageDir = t.TempDir()
+	services.UploadService.Dir = t.TempDir()
+	t.Cleanup(services.EventBroker.Close)
+
+	health := server.NewHealth(cnf, db, services)
+	srv.Config.Handler = server.NewRouter(cnf, services, health)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return &Server{
+		URL:      srv.URL,
+		Config:   cnf,
+		DB:       db,
+		Services: services,
+		Emails:   services.EmailService.Transport.(*models.MemoryTransport),
+	}
+}
+
+// NewDatabase creates an empty database next to the one in cnf, applies the
+// migrations and points cnf at it. The database is dropped when the test ends.
+func NewDatabase(t testing.TB, cnf *config.Config) *sql.DB {
+	t.Helper()
+
+	admin, err := database.NewDB(cnf)
+	if err != nil {
+		if os.Getenv("E2E") == "1" {
+			t.Fatalf("e2e: database unavailable: %v", err)
+		}
+		t.Skipf("e2e: database unavailable: %v (set E2E=1 to fail instead)", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	suffix, err := rand.Bytes(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "imago_e2e_" + hex.EncodeToString(suffix)
+
+	if _, err = admin.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("create database %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP DATABASE " + name + " WITH (FORCE)"); err != nil {
+			t.Errorf("drop database %s: %v", name, err)
+		}
+	})
+
+	cnf.DB.Database = name
+	db, err := database.NewDB(cnf)
+	if err != nil {
+		t.Fatalf("open database %s: %v", name, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = database.Migrate(context.Background(), db, database.FS, database.MigrationsDir); err != nil {
+		t.Fatalf("migrate database %s: %v", name, err)
+	}
+
+	return db
+}
+
+// LastEmail delivers the queued emails and returns the last one sent to to.
+func (s *Server) LastEmail(t testing.TB, to string) models.Email {
+	t.Helper()
+
+	if _, err := s.Services.EmailService.Dispatch(context.Background(), models.DefaultEmailBatchSize); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	email, ok := s.Emails.Last(to)
+	if !ok {
+		t.Fatalf("no email sent to %s", to)
+	}
+
+	return email
+}
@@ -0,0 +1,257 @@
+package e2e_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azdanov/imago/e2e"
+	"github.com/azdanov/imago/models"
+)
+
+func TestSignup(t *testing.T) {
+	s := e2e.NewServer(t)
+	form := url.Values{"email": {"ada@example.com"}, "password": {e2e.Password}}
+
+	res := s.Client(t).PostForm("/signup", form)
+	if res.URL.Path != "/users/me" {
+		t.Fatalf("sign up ended on %s, want /users/me", res.URL)
+	}
+	if !strings.Contains(res.Body, "ada@example.com") {
+		t.Errorf("profile page does not show the email")
+	}
+
+	res = s.Client(t).PostForm("/signup", form)
+	if res.URL.Path != "/signup" || !strings.Contains(res.Body, "Email already exists") {
+		t.Errorf("sign up with taken email ended on %s without an error", res.URL)
+	}
+}
+
+func TestSignin(t *testing.T) {
+	s := e2e.NewServer(t)
+	s.CreateUser(t, "ada@example.com")
+	c := s.Client(t)
+
+	res := c.PostForm("/signin", url.Values{"email": {"ada@example.com"}, "password": {"wrong password"}})
+	if res.URL.Path != "/signin" || !strings.Contains(res.Body, "Invalid email or password") {
+		t.Errorf("sign in with wrong password ended on %s without an error", res.URL)
+	}
+
+	c.SignIn("ada@example.com", e2e.Password)
+
+	res = c.PostForm("/signout", nil)
+	if res.URL.Path != "/signin" {
+		t.Errorf("sign out ended on %s, want /signin", res.URL)
+	}
+	if res = c.Get("/users/me"); res.URL.Path != "/signin" {
+		t.Errorf("profile after sign out ended on %s, want /signin", res.URL)
+	}
+}
+
+var resetLinkPattern = regexp.MustCompile(`http://\S+/reset-password\?token=\S+`)
+
+func TestPasswordReset(t *testing.T) {
+	s := e2e.NewServer(t)
+	s.CreateUser(t, "ada@example.com")
+	c := s.Client(t)
+
+	res := c.PostForm("/forgot-password", url.Values{"email": {"ada@example.com"}})
+	if !strings.Contains(res.Body, "An email has been sent") {
+		t.Fatalf("forgot password ended on %s without a confirmation", res.URL)
+	}
+
+	email := s.LastEmail(t, "ada@example.com")
+	link, err := url.Parse(resetLinkPattern.FindString(email.Plaintext))
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("reset email has no reset link:\n%s", email.Plaintext)
+	}
+
+	c.Get(link.RequestURI())
+	form := url.Values{"token": {link.Query().Get("token")}, "password": {"a brand new password"}}
+	if res = c.PostForm("/reset-password", form); res.URL.Path != "/users/me" {
+		t.Fatalf("reset password ended on %s, want /users/me", res.URL)
+	}
+
+	res = s.Client(t).PostForm("/reset-password", form)
+	if !strings.Contains(res.Body, "Invalid or expired token") {
+		t.Errorf("reusing the reset token ended on %s without an error", res.URL)
+	}
+
+	s.Client(t).SignIn("ada@example.com", "a brand new password")
+}
+
+func TestAccountDeletionCancelRacesPurge(t *testing.T) {
+	s := e2e.NewServer(t)
+	ctx := context.Background()
+	user := s.CreateUser(t, "ada@example.com")
+	gallery := s.CreateGallery(t, user, "Holidays")
+	s.CreateImage(t, gallery, "beach.png", e2e.PNG(t))
+
+	deletion := s.Services.AccountDeletion
+	schedule := func() {
+		t.Helper()
+
+		if _, err := deletion.Schedule(ctx, user.ID); err != nil {
+			t.Fatalf("Schedule() error = %v", err)
+		}
+		// Move the request back past the grace period.
+		query := `UPDATE users SET deletion_requested_at = NOW() - INTERVAL '30 days' WHERE id = $1`
+		if _, err := s.DB.Exec(query, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedule()
+
+	due, err := deletion.Due(ctx)
+	if err != nil || !slices.Contains(due, user.ID) {
+		t.Fatalf("Due() = %v, %v, want user %d", due, err, user.ID)
+	}
+	// The owner keeps the account between the purge job listing it and purging it.
+	if err = deletion.Cancel(ctx, user.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if err = deletion.Purge(ctx, user.ID); !errors.Is(err, models.ErrDeletionNotDue) {
+		t.Fatalf("Purge() after Cancel() error = %v, want ErrDeletionNotDue", err)
+	}
+	if _, err = s.Services.UserService.ByEmail(ctx, user.Email); err != nil {
+		t.Errorf("ByEmail() after cancelled purge error = %v", err)
+	}
+	if images, err := s.Services.GalleryService.Images(ctx, gallery.ID); err != nil || len(images) != 1 {
+		t.Errorf("Images() after cancelled purge = %v, %v, want the image kept", images, err)
+	}
+
+	// When both run at once, either the cancel wins and nothing is removed, or the
+	// purge wins and removes everything.
+	schedule()
+	cancelled := make(chan error)
+	go func() { cancelled <- deletion.Cancel(ctx, user.ID) }()
+	err = deletion.Purge(ctx, user.ID)
+	if cancelErr := <-cancelled; cancelErr != nil {
+		t.Fatalf("Cancel() error = %v", cancelErr)
+	}
+	_, userErr := s.Services.UserService.ByEmail(ctx, user.Email)
+	images, imagesErr := s.Services.GalleryService.Images(ctx, gallery.ID)
+	switch {
+	case errors.Is(err, models.ErrDeletionNotDue):
+		if userErr != nil || imagesErr != nil || len(images) != 1 {
+			t.Errorf("cancel won, but ByEmail() error = %v and Images() = %v, %v", userErr, images, imagesErr)
+		}
+	case err == nil:
+		if !errors.Is(userErr, models.ErrNotFound) || len(images) != 0 {
+			t.Errorf("purge won, but ByEmail() error = %v and Images() = %v", userErr, images)
+		}
+	default:
+		t.Fatalf("Purge() error = %v", err)
+	}
+}
+
+func TestAuditKeepsNoEmails(t *testing.T) {
+	s := e2e.NewServer(t)
+	ctx := context.Background()
+	user := s.CreateUser(t, "ada@example.com")
+	c := s.Client(t)
+
+	c.PostForm("/signin", url.Values{"email": {"ada@example.com"}, "password": {"wrong password"}})
+	c.PostForm("/signin", url.Values{"email": {"nobody@example.com"}, "password": {"wrong password"}})
+
+	events, err := s.Services.AuditService.All(ctx, 0)
+	if err != nil {
+		t.Fatalf("All() error = %v", err)
+	}
+	targets := map[string]bool{}
+	for _, event := range events {
+		if strings.Contains(event.Target, "@") {
+			t.Errorf("event %s has the email in its target %q", event.Action, event.Target)
+		}
+		targets[event.Target] = true
+	}
+	if !targets["user:"+strconv.Itoa(user.ID)] || !targets[models.AuditEmailTarget("nobody@example.com")] {
+		t.Errorf("failed sign-ins have targets %v, want the user and the hashed unknown email", targets)
+	}
+
+	if events, _ = s.Services.AuditService.ByUserID(ctx, user.ID, 0); len(events) != 1 || events[0].IP == "" {
+		t.Fatalf("ByUserID() = %+v, want the failed sign-in with its IP", events)
+	}
+	if _, err = s.Services.AccountDeletion.Schedule(ctx, user.ID); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	query := `UPDATE users SET deletion_requested_at = NOW() - INTERVAL '30 days' WHERE id = $1`
+	if _, err = s.DB.Exec(query, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Services.AccountDeletion.Purge(ctx, user.ID); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	events, _ = s.Services.AuditService.ByUserID(ctx, user.ID, 0)
+	for _, event := range events {
+		if event.IP != "" || event.Target != "" {
+			t.Errorf("event %s of purged user kept IP %q and target %q", event.Action, event.IP, event.Target)
+		}
+	}
+
+	if err = s.Services.AuditService.DeleteOlderThan(ctx, 0); err != nil {
+		t.Fatalf("DeleteOlderThan() error = %v", err)
+	}
+	if events, _ = s.Services.AuditService.All(ctx, 0); len(events) != 0 {
+		t.Errorf("All() after DeleteOlderThan(0) = %+v, want none", events)
+	}
+}
+
+func TestDataExportExpiresAfterBuild(t *testing.T) {
+	s := e2e.NewServer(t)
+	ctx := context.Background()
+	user := s.CreateUser(t, "ada@example.com")
+	exports := s.Services.DataExportService
+	exports.ExportDir = t.TempDir()
+
+	// A build job that waited in the queue past the download window.
+	export, err := exports.Create(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	query := `UPDATE data_exports SET created_at = NOW() - INTERVAL '3 days', expires_at = NOW() - INTERVAL '1 day'
+		WHERE id = $1`
+	if _, err = s.DB.Exec(query, export.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = exports.DeleteExpired(ctx); err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if export, err = exports.ByID(ctx, export.ID); err != nil {
+		t.Fatalf("ByID() error = %v, want the pending export kept", err)
+	}
+
+	if err = exports.Build(ctx, export); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if window := time.Until(export.ExpiresAt); window < models.DefaultExportLifetime-time.Minute {
+		t.Errorf("export expires in %v after Build, want %v", window, models.DefaultExportLifetime)
+	}
+	if _, err = exports.ByToken(ctx, export.Token); err != nil {
+		t.Errorf("ByToken() error = %v", err)
+	}
+
+	abandoned, err := exports.Create(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	query = `UPDATE data_exports SET created_at = NOW() - INTERVAL '8 days' WHERE id = $1`
+	if _, err = s.DB.Exec(query, abandoned.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = exports.DeleteExpired(ctx); err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if _, err = exports.ByID(ctx, abandoned.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("ByID() of abandoned export error = %v, want ErrNotFound", err)
+	}
+	if _, err = exports.ByID(ctx, export.ID); err != nil {
+		t.Errorf("ByID() of ready export error = %v, want it kept", err)
+	}
+}
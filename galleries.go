@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azdanov/imago/models"
+)
+
+func galleryList(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx := context.Background()
+	var galleries []models.Gallery
+	if email := fs.Arg(0); email != "" {
+		user, err := a.userByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		galleries, err = a.services.GalleryService.ByUserID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		galleries, err = a.services.GalleryService.All(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER ID\tIMAGES\tCREATED AT\tTITLE")
+	for _, gallery := range galleries {
+		images, err := a.services.GalleryService.Images(ctx, gallery.ID)
+		if err != nil {
+			return err
+		}
+		createdAt := gallery.CreatedAt.Local().Format(time.DateTime)
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\n", gallery.ID, gallery.UserID, len(images), createdAt, gallery.Title)
+	}
+
+	return tw.Flush()
+}
+
+func galleryDelete(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return errUsage
+	}
+
+	ctx := context.Background()
+	gallery, err := a.services.GalleryService.ByID(ctx, id)
+	if errors.Is(err, models.ErrNotFound) {
+		return fmt.Errorf("no gallery with ID %d", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = a.services.GalleryService.Delete(ctx, gallery.ID); err != nil {
+		return err
+	}
+	a.audit(ctx, models.AuditEvent{
+		OwnerID:  &gallery.UserID,
+		Action:   models.AuditGalleryDelete,
+		Target:   "gallery:" + strconv.Itoa(gallery.ID),
+		Metadata: map[string]string{"title": gallery.Title},
+	})
+	fmt.Printf("Deleted gallery %d %q\n", gallery.ID, gallery.Title)
+
+	return nil
+}
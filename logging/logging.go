@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/azdanov/imago/config"
+	"github.com/go-chi/chi/v5"
+)
+
+type key int
+
+const loggerKey key = iota
+
+// New creates the application logger. Production writes JSON for log collectors,
+// development writes text that is easier to read in a terminal.
+func New(w io.Writer, env config.Environment, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if env == config.Prod {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// holder lets attributes added further down a request, like the user ID, show up on
+// the access log line written by the middleware that created it.
+type holder struct {
+	logger atomic.Pointer[slog.Logger]
+}
+
+// WithLogger returns a copy of ctx carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	h := &holder{}
+	h.logger.Store(logger)
+	return context.WithValue(ctx, loggerKey, h)
+}
+
+// With adds attributes to the logger in ctx, for every later line of the request. It
+// does nothing when ctx carries no logger.
+func With(ctx context.Context, args ...any) {
+	h, ok := ctx.Value(loggerKey).(*holder)
+	if !ok {
+		return
+	}
+	h.logger.Store(h.logger.Load().With(args...))
+}
+
+// FromContext returns the logger in ctx, or the default logger. Inside a request it
+// also carries the matched route pattern, like /galleries/{id}.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if h, ok := ctx.Value(loggerKey).(*holder); ok {
+		logger = h.logger.Load()
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		logger = logger.With("route", rctx.RoutePattern())
+	}
+
+	return logger
+}
@@ -1,187 +1,196 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"time"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
 
 	"github.com/azdanov/imago/config"
-	"github.com/azdanov/imago/controllers"
 	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/logging"
 	"github.com/azdanov/imago/models"
-	"github.com/azdanov/imago/templates"
-	"github.com/azdanov/imago/views"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/gorilla/csrf"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/azdanov/imago/server"
+	"golang.org/x/term"
 )
 
-const (
-	readTimeout  = 15 * time.Second
-	writeTimeout = 15 * time.Second
-	idleTimeout  = 60 * time.Second
-)
+// command is a subcommand of imago, like "user create".
+type command struct {
+	name string
+	// args describes the arguments following the flags.
+	args string
+	help string
+	run  func(fs *flag.FlagSet, args []string) error
+}
 
-func main() {
-	// Load environment variables
-	cnf := config.NewEnvConfig()
+var commands = []command{
+	{name: "serve", help: "Run the web server, applying pending migrations first.", run: serve},
+	{name: "migrate up", help: "Apply all pending migrations.", run: migrateUp},
+	{name: "migrate down", help: "Roll back the latest migration.", run: migrateDown},
+	{name: "migrate status", help: "List the migrations and whether they are applied.", run: migrateStatus},
+	{name: "migrate redo", help: "Roll back the latest migration and apply it again.", run: migrateRedo},
+	{name: "user create", args: "EMAIL", help: "Create a user. The password is read from stdin.", run: userCreate},
+	{name: "user list", args: "[TERM]", help: "List users, or those whose email contains TERM.", run: userList},
+	{
+		name: "user set-password",
+		args: "EMAIL",
+		help: "Change the password of a user. The password is read from stdin.",
+		run:  userSetPassword,
+	},
+	{name: "user disable", args: "EMAIL", help: "Disable a user and sign them out.", run: userDisable},
+	{name: "gallery list", args: "[EMAIL]", help: "List galleries, or those of one user.", run: galleryList},
+	{name: "gallery delete", args: "ID", help: "Delete a gallery and its images.", run: galleryDelete},
+	{name: "sessions purge", args: "[EMAIL]", help: "Sign out every user, or one user.", run: sessionsPurge},
+	{
+		name: "images verify",
+		help: "Report image files that do not belong to a gallery or are not valid images.",
+		run:  imagesVerify,
+	},
+}
 
-	// Setup database
-	db, err := setupDatabase(cnf)
-	if err != nil {
-		log.Fatalf("Unable to setup database: %v", err)
+// errUsage reports that a command was called with the wrong arguments.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	if len(os.Args) == 2 && slices.Contains([]string{"help", "-h", "-help", "--help"}, os.Args[1]) {
+		usage()
+		return
 	}
 
-	// Setup services
-	services := setupServices(db, cnf)
+	cmd, args, ok := findCommand(os.Args[1:])
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
 
-	// Setup router and routes
-	r := setupRouter(cnf, services)
+	fs := flag.NewFlagSet("imago "+cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: imago %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
 
-	// Start server
-	log.Printf("Starting server on %s\n", cnf.Server.GetURL())
-	srv := &http.Server{
-		Handler:      r,
-		Addr:         cnf.Server.GetAddr(),
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
-		IdleTimeout:  idleTimeout,
+	err := cmd.run(fs, args)
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		os.Exit(2)
 	}
-	if err = srv.ListenAndServe(); err != nil {
-		log.Fatalf("Unable to start server: %v", err)
+	if err != nil {
+		fatal("Command failed", "command", cmd.name, "error", err)
 	}
 }
 
-func setupDatabase(cnf *config.Config) (*sql.DB, error) {
-	db, err := database.NewDB(cnf)
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect to database: %w", err)
+// findCommand returns the command named by the leading words of args, and the
+// arguments that follow its name.
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		name := strings.Fields(cmd.name)
+		if len(args) >= len(name) && strings.Join(args[:len(name)], " ") == cmd.name {
+			return cmd, args[len(name):], true
+		}
 	}
+	return command{}, nil, false
+}
 
-	err = database.Migrate(db, database.FS, database.MigrationsDir)
-	if err != nil {
-		return nil, fmt.Errorf("unable to migrate database: %w", err)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: imago <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.help)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun imago <command> -h for the flags of a command.\n")
+}
 
-	return db, nil
+// fatal logs msg with args as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-type services struct {
-	sessionService       *models.SessionService
-	userService          *models.UserService
-	sessionCookie        *controllers.SessionCookie
-	passwordResetService *models.PasswordResetService
-	emailService         *models.EmailService
-	galleryService       *models.GalleryService
+// app is what the administration commands share. Unlike serve, they log to stderr,
+// keeping stdout for their output, and do not migrate the database.
+type app struct {
+	cnf      *config.Config
+	db       *sql.DB
+	services *server.Services
 }
 
-func setupServices(db *sql.DB, cnf *config.Config) *services {
-	ss := models.NewSessionService(db, models.MinSessionTokenBytes)
-	us := models.NewUserService(db)
-	sc := controllers.NewSessionCookie(cnf.Server.SSLMode)
-	ps := models.NewPasswordResetService(db, models.MinSessionTokenBytes, models.DefaultTokenLifetime)
-	es, err := models.NewEmailService(cnf)
+// open loads the configuration with the flags of fs, then connects to the database
+// and creates the services. It returns errUsage unless minArgs to maxArgs arguments
+// follow the flags.
+func open(fs *flag.FlagSet, args []string, minArgs, maxArgs int) (*app, error) {
+	cnf, err := config.Load(fs, args)
 	if err != nil {
-		log.Fatalf("Unable to create email service: %v", err)
+		return nil, err
 	}
-	gs := models.NewGalleryService(db)
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		return nil, errUsage
+	}
+	slog.SetDefault(logging.New(os.Stderr, cnf.Server.Env, cnf.Log.Level))
 
-	return &services{
-		sessionService:       ss,
-		userService:          us,
-		sessionCookie:        sc,
-		passwordResetService: ps,
-		emailService:         es,
-		galleryService:       gs,
+	db, err := database.NewDB(cnf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
+	services, err := server.NewServices(db, cnf)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to setup services: %w", err)
+	}
+
+	return &app{cnf: cnf, db: db, services: services}, nil
 }
 
-func setupRouter(cnf *config.Config, s *services) *chi.Mux {
-	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(csrf.Protect([]byte(cnf.CSRF.Key),
-		csrf.Secure(cnf.CSRF.Secure),
-		csrf.TrustedOrigins([]string{cnf.Server.GetAddr()}),
-	))
+func (a *app) Close() {
+	a.services.EventBroker.Close()
+	if err := a.db.Close(); err != nil {
+		slog.Error("Unable to close database", "error", err)
+	}
+}
 
-	um := controllers.NewUserMiddleware(s.sessionService, s.sessionCookie)
-	r.Use(um.SetUser)
+// userByEmail returns the user with the email, with a clear error when there is none.
+func (a *app) userByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := a.services.UserService.ByEmail(ctx, email)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, fmt.Errorf("no user with email %s", email)
+	}
+	return user, err
+}
 
-	notificationMiddleware := controllers.NewNotificationMiddleware()
-	r.Use(notificationMiddleware.ExtractNotifications)
+// audit records event, logging instead of failing the command when it cannot.
+func (a *app) audit(ctx context.Context, event models.AuditEvent) {
+	if err := a.services.AuditService.Record(ctx, event); err != nil {
+		slog.Error("Unable to record audit event", "action", event.Action, "error", err)
+	}
+}
 
-	setupRoutes(r, s, um, cnf)
+// readPassword reads a password from stdin, prompting for it without echo when stdin
+// is a terminal. Otherwise the first line is the password, so it can be piped in.
+func readPassword() (string, error) {
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		password = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
 
-	return r
-}
+	if len(password) < models.MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters long", models.MinPasswordLength)
+	}
 
-func setupRoutes(r *chi.Mux, s *services, um *controllers.UserMiddleware, cnf *config.Config) {
-	// Static routes
-	tmpl := views.Must(views.Parse(templates.FS, "home.tmpl.html"))
-	r.Get("/", controllers.StaticHandler(tmpl))
-
-	tmpl = views.Must(views.Parse(templates.FS, "contact.tmpl.html"))
-	r.Get("/contact", controllers.StaticHandler(tmpl))
-
-	tmpl = views.Must(views.Parse(templates.FS, "faq.tmpl.html"))
-	r.Get("/faq", controllers.FAQ(tmpl))
-
-	// User routes
-	usersC := controllers.NewUsers(
-		s.userService, s.sessionService, s.sessionCookie, s.passwordResetService, s.emailService, cnf)
-
-	usersC.Templates.SignUp = views.Must(views.Parse(templates.FS, "signup.tmpl.html"))
-	r.Get("/signup", usersC.NewSignup)
-	r.Post("/signup", usersC.HandleSignup)
-
-	usersC.Templates.SignIn = views.Must(views.Parse(templates.FS, "signin.tmpl.html"))
-	r.Get("/signin", usersC.NewSignin)
-	r.Post("/signin", usersC.HandleSignin)
-	r.Post("/signout", usersC.HandleSignout)
-
-	usersC.Templates.ForgotPassword = views.Must(views.Parse(templates.FS, "forgot_password.tmpl.html"))
-	r.Get("/forgot-password", usersC.NewForgotPassword)
-	r.Post("/forgot-password", usersC.HandleForgotPassword)
-
-	usersC.Templates.ResetPassword = views.Must(views.Parse(templates.FS, "reset_password.tmpl.html"))
-	r.Get("/reset-password", usersC.NewResetPassword)
-	r.Post("/reset-password", usersC.HandleResetPassword)
-
-	tmpl = views.Must(views.Parse(templates.FS, "me.tmpl.html"))
-	r.Route("/users/me", func(r chi.Router) {
-		r.Use(um.RequireUser)
-		r.Get("/", controllers.StaticHandler(tmpl))
-	})
-
-	// Gallery routes
-	galleriesC := controllers.NewGalleries(s.galleryService)
-	galleriesC.Templates.New = views.Must(views.Parse(templates.FS, "galleries/new.tmpl.html"))
-	galleriesC.Templates.Edit = views.Must(views.Parse(templates.FS, "galleries/edit.tmpl.html"))
-	galleriesC.Templates.Show = views.Must(views.Parse(templates.FS, "galleries/show.tmpl.html"))
-	galleriesC.Templates.List = views.Must(views.Parse(templates.FS, "galleries/list.tmpl.html"))
-	r.Route("/galleries", func(r chi.Router) {
-		r.Get("/{id}", galleriesC.Show)
-		r.Get("/{id}/images/{filename}", galleriesC.Image)
-		r.Group(func(r chi.Router) {
-			r.Use(um.RequireUser)
-			r.Get("/", galleriesC.List)
-			r.Get("/new", galleriesC.New)
-			r.Post("/", galleriesC.Create)
-			r.Get("/{id}/edit", galleriesC.Edit)
-			r.Post("/{id}", galleriesC.Update)
-			r.Post("/{id}/delete", galleriesC.Delete)
-			r.Post("/{id}/images", galleriesC.UploadImage)
-			r.Post("/{id}/images/{filename}/delete", galleriesC.DeleteImage)
-		})
-	})
-
-	// 404 handler
-	tmpl = views.Must(views.Parse(templates.FS, "404.tmpl.html"))
-	r.NotFound(controllers.StaticHandler(tmpl))
+	return password, nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// sessionsPurge signs out every user, or the user with the email given.
+func sessionsPurge(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx := context.Background()
+	if email := fs.Arg(0); email != "" {
+		user, err := a.userByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		if err = a.revokeSessions(ctx, user); err != nil {
+			return err
+		}
+		fmt.Printf("Signed out %s\n", user.Email)
+		return nil
+	}
+
+	count, err := a.services.SessionService.DeleteAll(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d sessions\n", count)
+
+	return nil
+}
+
+// imagesVerify lists the problems found in the images directory, and fails when there
+// are any.
+func imagesVerify(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	problems, err := a.services.GalleryService.VerifyImages(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", problem.Path, problem.Issue)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in %s", len(problems), a.services.GalleryService.ImagesDir())
+	}
+	fmt.Printf("All images in %s are valid\n", a.services.GalleryService.ImagesDir())
+
+	return nil
+}
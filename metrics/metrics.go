@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "imago"
+
+// Registry holds every metric of the application, together with the Go runtime and
+// process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	ImageUploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_uploads_total",
+		Help:      "Images stored, from form uploads, resumable uploads and ZIP imports.",
+	})
+
+	ImageUploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_upload_bytes_total",
+		Help:      "Bytes of stored images.",
+	})
+
+	ImageServes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_serves_total",
+		Help:      "Images served, by cache result. A hit is a conditional request answered with 304 Not Modified.",
+	}, []string{"cache"})
+
+	Emails = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Email delivery attempts by result: sent, retry or failed.",
+	}, []string{"result"})
+
+	SessionsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_created_total",
+		Help:      "Sessions created by signing in or up.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		ImageUploads,
+		ImageUploadBytes,
+		ImageServes,
+		Emails,
+		SessionsCreated,
+	)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/logging"
+	"github.com/pressly/goose/v3"
+)
+
+func migrateUp(fs *flag.FlagSet, args []string) error {
+	migrations, err := openMigrations(fs, args)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	results, err := migrations.Up(context.Background())
+	printResults(results...)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No pending migrations")
+	}
+
+	return nil
+}
+
+func migrateDown(fs *flag.FlagSet, args []string) error {
+	migrations, err := openMigrations(fs, args)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	result, err := migrations.Down(context.Background())
+	if err != nil {
+		return err
+	}
+	printResults(result)
+
+	return nil
+}
+
+func migrateRedo(fs *flag.FlagSet, args []string) error {
+	migrations, err := openMigrations(fs, args)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	ctx := context.Background()
+	result, err := migrations.Down(ctx)
+	if err != nil {
+		return err
+	}
+	printResults(result)
+
+	result, err = migrations.UpByOne(ctx)
+	if err != nil {
+		return err
+	}
+	printResults(result)
+
+	return nil
+}
+
+func migrateStatus(fs *flag.FlagSet, args []string) error {
+	migrations, err := openMigrations(fs, args)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	statuses, err := migrations.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, status.Source.Path)
+	}
+
+	return tw.Flush()
+}
+
+// openMigrations loads the configuration with the flags of fs and connects to the
+// database. Closing the migrations closes the database.
+func openMigrations(fs *flag.FlagSet, args []string) (*goose.Provider, error) {
+	cnf, err := config.Load(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, errUsage
+	}
+	slog.SetDefault(logging.New(os.Stderr, cnf.Server.Env, cnf.Log.Level))
+
+	db, err := database.NewDB(cnf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	migrations, err := database.NewMigrations(db, database.FS, database.MigrationsDir)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+func printResults(results ...*goose.MigrationResult) {
+	for _, result := range results {
+		fmt.Println(result)
+	}
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/azdanov/imago/logging"
+)
+
+const (
+	DefaultDeletionGracePeriod = 7 * 24 * time.Hour
+)
+
+// AccountDeletionService handles self-service account deletion. A request only marks
+// the account; the data is purged once the grace period has passed, which gives the
+// owner a chance to change their mind.
+type AccountDeletionService struct {
+	DB             *sql.DB
+	GalleryService *GalleryService
+	// GracePeriod is how long an account waits before being purged.
+	// If zero, DefaultDeletionGracePeriod is used.
+	GracePeriod time.Duration
+}
+
+func NewAccountDeletionService(db *sql.DB, gs *GalleryService, gracePeriod time.Duration) *AccountDeletionService {
+	return &AccountDeletionService{
+		DB:             db,
+		GalleryService: gs,
+		GracePeriod:    gracePeriod,
+	}
+}
+
+// Schedule marks the account for deletion and signs it out everywhere. It returns
+// the time after which the account will be purged.
+func (s *AccountDeletionService) Schedule(ctx context.Context, userID int) (time.Time, error) {
+	var requestedAt time.Time
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE users SET deletion_requested_at = COALESCE(deletion_requested_at, NOW())
+		WHERE id = $1
+		RETURNING deletion_requested_at;`, userID).Scan(&requestedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("schedule deletion: %w", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1;`, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("schedule deletion: %w", err)
+	}
+
+	return s.PurgeAfter(requestedAt), nil
+}
+
+func (s *AccountDeletionService) Cancel(ctx context.Context, userID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET deletion_requested_at = NULL WHERE id = $1;`, userID)
+	if err != nil {
+		return fmt.Errorf("cancel deletion: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeAfter returns the time at which an account requested for deletion at requestedAt is purged.
+func (s *AccountDeletionService) PurgeAfter(requestedAt time.Time) time.Time {
+	return requestedAt.Add(s.gracePeriod())
+}
+
+func (s *AccountDeletionService) gracePeriod() time.Duration {
+	if s.GracePeriod == 0 {
+		return DefaultDeletionGracePeriod
+	}
+	return s.GracePeriod
+}
+
+// Purge permanently removes the user together with their sessions, reset tokens,
+// data exports, galleries and image files, and anonymises their audit events. It
+// returns ErrDeletionNotDue when the deletion was cancelled or is not yet due. The
+// user row stays locked from that check until the user is deleted, so a concurrent
+// Cancel either wins or waits for the purge.
+//
+// Every row is deleted in one transaction, so a failure leaves the account intact
+// to be retried. Image files and export archives are only removed once it commits;
+// a file that cannot be removed then is logged and left behind, since the account
+// is gone either way.
+func (s *AccountDeletionService) Purge(ctx context.Context, userID int) error {
+	cutoff := time.Now().Add(-s.gracePeriod())
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is a no-op
+
+	qctx, cancel := queryContext(ctx)
+	defer cancel()
+	err = tx.QueryRowContext(qctx, `
+		SELECT id FROM users
+		WHERE id = $1 AND deletion_requested_at IS NOT NULL AND deletion_requested_at <= $2
+		FOR UPDATE;`, userID, cutoff).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDeletionNotDue
+	}
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+
+	galleries, err := s.GalleryService.ByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	archives, err := exportArchives(qctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id = $1;`,
+		`DELETE FROM reset_tokens WHERE user_id = $1;`,
+		`DELETE FROM data_exports WHERE user_id = $1;`,
+		`DELETE FROM galleries WHERE user_id = $1;`,
+		// The audit log is append-only, only this function may clear its personal data.
+		`SELECT audit_events_anonymize($1);`,
+	} {
+		if _, err = tx.ExecContext(qctx, query, userID); err != nil {
+			return fmt.Errorf("purge: %w", err)
+		}
+	}
+
+	res, err := tx.ExecContext(qctx, `
+		DELETE FROM users
+		WHERE id = $1 AND deletion_requested_at IS NOT NULL AND deletion_requested_at <= $2;`, userID, cutoff)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	if deleted == 0 {
+		return ErrDeletionNotDue
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+
+	logger := logging.FromContext(ctx)
+	for _, gallery := range galleries {
+		if err = s.GalleryService.removeDeleted(ctx, gallery); err != nil {
+			logger.Error("Unable to remove images of purged account", "gallery_id", gallery.ID, "error", err)
+		}
+	}
+	for _, archivePath := range archives {
+		if err = os.Remove(archivePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("Unable to remove export of purged account", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// exportArchives returns the paths of the user's finished export archives.
+func exportArchives(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT path FROM data_exports WHERE user_id = $1 AND path <> '';`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("export archives: %w", err)
+	}
+	defer rows.Close()
+
+	var archives []string
+	for rows.Next() {
+		var archivePath string
+		if err = rows.Scan(&archivePath); err != nil {
+			return nil, fmt.Errorf("export archives: %w", err)
+		}
+		archives = append(archives, archivePath)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("export archives: %w", err)
+	}
+
+	return archives, nil
+}
+
+// Due returns the IDs of accounts whose grace period has passed.
+func (s *AccountDeletionService) Due(ctx context.Context) ([]int, error) {
+	cutoff := time.Now().Add(-s.gracePeriod())
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id FROM users
+		WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at <= $1
+		ORDER BY id;`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("due deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+
+	for rows.Next() {
+		var userID int
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("due deletions: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("due deletions: %w", err)
+	}
+
+	return userIDs, nil
+}
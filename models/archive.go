@@ -0,0 +1,57 @@
+package models
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// addFileToZip copies the file at src into the archive under name. Images are already
+// compressed, so entries are stored as-is rather than deflated again.
+func addFileToZip(zw *zip.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %v: %w", src, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("opening %v: %w", src, err)
+	}
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: info.ModTime(),
+	})
+	if err != nil {
+		return fmt.Errorf("adding %v to archive: %w", name, err)
+	}
+
+	if _, err = io.Copy(w, f); err != nil {
+		return fmt.Errorf("adding %v to archive: %w", name, err)
+	}
+
+	return nil
+}
+
+// uniqueName returns name, or name with a " (n)" suffix before the extension if it was
+// already handed out. Names are compared case-insensitively, since archives are often
+// extracted on case-insensitive file systems.
+func uniqueName(name string, seen map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; seen[strings.ToLower(candidate)]; i++ {
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	seen[strings.ToLower(candidate)] = true
+
+	return candidate
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditSignIn               AuditAction = "user.signin"
+	AuditSignInFailed         AuditAction = "user.signin_failed"
+	AuditSignOut              AuditAction = "user.signout"
+	AuditPasswordResetRequest AuditAction = "user.password_reset_request"
+	AuditPasswordReset        AuditAction = "user.password_reset"
+	AuditUserDisable          AuditAction = "user.disable"
+	AuditUserEnable           AuditAction = "user.enable"
+	AuditSessionRevoke        AuditAction = "session.revoke"
+	AuditAccountDeleteRequest AuditAction = "user.delete_request"
+	AuditAccountDeleteCancel  AuditAction = "user.delete_cancel"
+	AuditAccountDelete        AuditAction = "user.delete"
+	AuditDataExportRequest    AuditAction = "user.export_request"
+	AuditDataExportDownload   AuditAction = "user.export_download"
+	AuditGalleryCreate        AuditAction = "gallery.create"
+	AuditGalleryUpdate        AuditAction = "gallery.update"
+	AuditGalleryDelete        AuditAction = "gallery.delete"
+	AuditImageUpload          AuditAction = "image.upload"
+	AuditImageDelete          AuditAction = "image.delete"
+)
+
+const (
+	// DefaultAuditLimit is the number of events returned by listing methods when no limit is given.
+	DefaultAuditLimit = 100
+	// DefaultAuditMaxAge is how long events are kept before the retention job removes them.
+	DefaultAuditMaxAge = 365 * 24 * time.Hour
+)
+
+type AuditEvent struct {
+	ID int64 `json:"id"`
+	// ActorID is the user who performed the action. It is nil for anonymous actors,
+	// like a failed sign-in attempt.
+	ActorID *int `json:"actor_id,omitempty"`
+	// OwnerID is the user whose account or content the action concerns. It lets owners
+	// see actions an admin performed on their behalf.
+	OwnerID   *int              `json:"owner_id,omitempty"`
+	IP        string            `json:"ip"`
+	RequestID string            `json:"request_id"`
+	Action    AuditAction       `json:"action"`
+	Target    string            `json:"target"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// AuditService records security-relevant and content events. Events are never
+// updated once written, except that the events of a purged account are anonymised,
+// see AccountDeletionService.Purge. DeleteOlderThan removes them after a while.
+type AuditService struct {
+	DB *sql.DB
+}
+
+func NewAuditService(db *sql.DB) *AuditService {
+	return &AuditService{
+		DB: db,
+	}
+}
+
+func (s *AuditService) Record(ctx context.Context, event AuditEvent) error {
+	metadata := []byte("{}")
+	if event.Metadata != nil {
+		var err error
+		metadata, err = json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("record audit event: %w", err)
+		}
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO audit_events (actor_id, owner_id, ip, request_id, action, target, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	_, err := s.DB.ExecContext(ctx, query, event.ActorID, event.OwnerID, event.IP, event.RequestID,
+		event.Action, event.Target, metadata)
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteOlderThan removes the events older than maxAge.
+func (s *AuditService) DeleteOlderThan(ctx context.Context, maxAge time.Duration) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// The table is append-only, only this function may delete from it.
+	_, err := s.DB.ExecContext(ctx, `SELECT audit_events_delete_before($1);`, time.Now().Add(-maxAge))
+	if err != nil {
+		return fmt.Errorf("delete audit events: %w", err)
+	}
+
+	return nil
+}
+
+// AuditEmailTarget is the target of an event about an email that belongs to no
+// account, like a failed sign-in. Only a hash of the email is kept, which still
+// shows repeated attempts on the same address.
+func AuditEmailTarget(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return "email:" + hex.EncodeToString(sum[:])
+}
+
+// ByUserID returns the most recent events the user performed or that concern the user.
+func (s *AuditService) ByUserID(ctx context.Context, userID int, limit int) ([]AuditEvent, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, actor_id, owner_id, ip, request_id, action, target, metadata, created_at
+		FROM audit_events
+		WHERE actor_id = $1 OR owner_id = $1
+		ORDER BY id DESC
+		LIMIT $2;`
+	rows, err := s.DB.QueryContext(ctx, query, userID, s.limit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("query audit events by user id: %w", err)
+	}
+	defer rows.Close()
+
+	return s.scan(rows)
+}
+
+// All returns the most recent events across every user.
+func (s *AuditService) All(ctx context.Context, limit int) ([]AuditEvent, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, actor_id, owner_id, ip, request_id, action, target, metadata, created_at
+		FROM audit_events
+		ORDER BY id DESC
+		LIMIT $1;`
+	rows, err := s.DB.QueryContext(ctx, query, s.limit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	return s.scan(rows)
+}
+
+func (s *AuditService) limit(limit int) int {
+	if limit <= 0 {
+		return DefaultAuditLimit
+	}
+	return limit
+}
+
+func (s *AuditService) scan(rows *sql.Rows) ([]AuditEvent, error) {
+	var events []AuditEvent
+
+	for rows.Next() {
+		var event AuditEvent
+		var metadata []byte
+		err := rows.Scan(&event.ID, &event.ActorID, &event.OwnerID, &event.IP, &event.RequestID,
+			&event.Action, &event.Target, &metadata, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan audit event row: %w", err)
+		}
+		if err = json.Unmarshal(metadata, &event.Metadata); err != nil {
+			return nil, fmt.Errorf("decode audit event metadata: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate audit event rows: %w", err)
+	}
+
+	return events, nil
+}
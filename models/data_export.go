@@ -0,0 +1,387 @@
+package models
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/azdanov/imago/rand"
+)
+
+const (
+	DefaultExportLifetime = 48 * time.Hour
+	// DefaultPendingExportMaxAge is how long an export may stay pending before
+	// DeleteExpired gives up on it. It is well beyond the retries of its build job.
+	DefaultPendingExportMaxAge = 7 * 24 * time.Hour
+)
+
+type DataExportStatus string
+
+const (
+	DataExportPending DataExportStatus = "pending"
+	DataExportReady   DataExportStatus = "ready"
+	DataExportFailed  DataExportStatus = "failed"
+)
+
+type DataExport struct {
+	ID     int
+	UserID int
+	// Token is only created initially and never stored in the database.
+	Token     string
+	TokenHash string
+	Status    DataExportStatus
+	Path      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// DataExportService builds ZIP archives with everything stored about a user and
+// hands them out through time-limited download tokens.
+type DataExportService struct {
+	DB             *sql.DB
+	GalleryService *GalleryService
+	// ExportDir is where finished archives are stored. Defaults to "exports".
+	ExportDir string
+	// BytesPerToken is the number of bytes used to generate a download token.
+	// If the value is less than MinSessionTokenBytes, MinSessionTokenBytes will be used.
+	BytesPerToken int
+	// Lifetime is how long a finished archive can be downloaded, counted from when
+	// it is ready.
+	Lifetime time.Duration
+}
+
+func NewDataExportService(db *sql.DB, gs *GalleryService, bytesPerToken int, lifetime time.Duration) *DataExportService {
+	return &DataExportService{
+		DB:             db,
+		GalleryService: gs,
+		BytesPerToken:  bytesPerToken,
+		Lifetime:       lifetime,
+	}
+}
+
+// Create registers a pending export for the user. The archive itself is produced by Build.
+func (s *DataExportService) Create(ctx context.Context, userID int) (*DataExport, error) {
+	token, err := rand.String(max(s.BytesPerToken, MinSessionTokenBytes))
+	if err != nil {
+		return nil, fmt.Errorf("create export: %w", err)
+	}
+
+	export := DataExport{
+		UserID:    userID,
+		Token:     token,
+		TokenHash: s.hash(token),
+		Status:    DataExportPending,
+		CreatedAt: time.Now(),
+	}
+	// Replaced by Build, a pending export cannot be downloaded.
+	export.ExpiresAt = export.CreatedAt.Add(s.lifetime())
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO data_exports (user_id, token_hash, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`, export.UserID, export.TokenHash, export.Status, export.CreatedAt, export.ExpiresAt,
+	).Scan(&export.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create export: %w", err)
+	}
+
+	return &export, nil
+}
+
+// Build writes the archive for a pending export and marks it ready. On failure the
+// export is marked failed and any partial archive is removed.
+//
+// A new download token is issued once the archive is ready, because Build usually
+// runs in a background job that never saw the token returned by Create. The download
+// window starts then too, so time spent queued or retrying does not shorten it.
+func (s *DataExportService) Build(ctx context.Context, export *DataExport) error {
+	err := s.build(ctx, export)
+	if err != nil {
+		export.Status = DataExportFailed
+		qctx, cancel := queryContext(ctx)
+		defer cancel()
+		_, dbErr := s.DB.ExecContext(qctx, `UPDATE data_exports SET status = $1 WHERE id = $2;`, export.Status, export.ID)
+		return errors.Join(fmt.Errorf("build export: %w", err), dbErr)
+	}
+
+	token, err := rand.String(max(s.BytesPerToken, MinSessionTokenBytes))
+	if err != nil {
+		return fmt.Errorf("build export: %w", err)
+	}
+	export.Token = token
+	export.TokenHash = s.hash(token)
+
+	export.Status = DataExportReady
+	export.ExpiresAt = time.Now().Add(s.lifetime())
+	qctx, cancel := queryContext(ctx)
+	defer cancel()
+	_, err = s.DB.ExecContext(qctx, `
+		UPDATE data_exports SET status = $1, path = $2, token_hash = $3, expires_at = $4
+		WHERE id = $5;`, export.Status, export.Path, export.TokenHash, export.ExpiresAt, export.ID)
+	if err != nil {
+		return fmt.Errorf("build export: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DataExportService) ByID(ctx context.Context, id int) (*DataExport, error) {
+	export := DataExport{}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, user_id, token_hash, status, path, created_at, expires_at
+		FROM data_exports
+		WHERE id = $1;`, id,
+	).Scan(&export.ID, &export.UserID, &export.TokenHash, &export.Status, &export.Path,
+		&export.CreatedAt, &export.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("export by id: %w", err)
+	}
+
+	return &export, nil
+}
+
+// ByToken returns a ready, unexpired export for the given download token.
+func (s *DataExportService) ByToken(ctx context.Context, token string) (*DataExport, error) {
+	export := DataExport{}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, user_id, token_hash, status, path, created_at, expires_at
+		FROM data_exports
+		WHERE token_hash = $1 AND status = $2 AND expires_at > NOW();`, s.hash(token), DataExportReady,
+	).Scan(&export.ID, &export.UserID, &export.TokenHash, &export.Status, &export.Path,
+		&export.CreatedAt, &export.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("export by token: %w", err)
+	}
+
+	return &export, nil
+}
+
+// DeleteExpired removes expired exports together with their archives. Pending exports
+// may still be building, they are only removed once DefaultPendingExportMaxAge has
+// passed since they were requested.
+func (s *DataExportService) DeleteExpired(ctx context.Context) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, path FROM data_exports
+		WHERE (status <> $1 AND expires_at <= $2) OR (status = $1 AND created_at <= $3);`,
+		DataExportPending, now, now.Add(-DefaultPendingExportMaxAge))
+	if err != nil {
+		return fmt.Errorf("delete expired exports: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		var archivePath string
+		if err = rows.Scan(&id, &archivePath); err != nil {
+			return fmt.Errorf("delete expired exports: %w", err)
+		}
+		if archivePath != "" {
+			if err = os.Remove(archivePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("delete expired exports: %w", err)
+			}
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("delete expired exports: %w", err)
+	}
+
+	for _, id := range ids {
+		if _, err = s.DB.ExecContext(ctx, `DELETE FROM data_exports WHERE id = $1;`, id); err != nil {
+			return fmt.Errorf("delete expired exports: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *DataExportService) lifetime() time.Duration {
+	if s.Lifetime == 0 {
+		return DefaultExportLifetime
+	}
+	return s.Lifetime
+}
+
+// exportManifest is the JSON document at the root of every export archive.
+type exportManifest struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	User        User            `json:"user"`
+	Sessions    []exportSession `json:"sessions"`
+	Galleries   []exportGallery `json:"galleries"`
+}
+
+type exportSession struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportGallery struct {
+	Gallery
+	Images []exportImage `json:"images"`
+}
+
+type exportImage struct {
+	Filename   string    `json:"filename"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func (s *DataExportService) build(ctx context.Context, export *DataExport) error {
+	manifest, err := s.manifest(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	exportDir := s.ExportDir
+	if exportDir == "" {
+		exportDir = "exports"
+	}
+	if err = os.MkdirAll(exportDir, 0o750); err != nil {
+		return fmt.Errorf("creating exports directory: %w", err)
+	}
+
+	archivePath := filepath.Join(exportDir, fmt.Sprintf("export_%d.zip", export.ID))
+	tmp, err := os.CreateTemp(exportDir, "export_*.zip.tmp")
+	if err != nil {
+		return fmt.Errorf("creating export archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := zip.NewWriter(tmp)
+
+	for _, gallery := range manifest.Galleries {
+		for _, image := range gallery.Images {
+			err = addFileToZip(zw, image.Path, filepath.Join(s.GalleryService.galleryDir(gallery.ID), image.Filename))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(manifest); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("closing export archive: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("closing export archive: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), archivePath); err != nil {
+		return fmt.Errorf("moving export archive: %w", err)
+	}
+
+	export.Path = archivePath
+	return nil
+}
+
+func (s *DataExportService) manifest(ctx context.Context, userID int) (*exportManifest, error) {
+	manifest := exportManifest{
+		GeneratedAt: time.Now(),
+		Sessions:    []exportSession{},
+		Galleries:   []exportGallery{},
+	}
+
+	u := &manifest.User
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, email, role, locale, disabled_at, deletion_requested_at, created_at
+		FROM users
+		WHERE id = $1;`, userID).Scan(&u.ID, &u.Email, &u.Role, &u.Locale, &u.DisabledAt, &u.DeletionRequestedAt, &u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("querying user: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, created_at FROM sessions WHERE user_id = $1 ORDER BY id;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("querying sessions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session exportSession
+		if err = rows.Scan(&session.ID, &session.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan session row: %w", err)
+		}
+		manifest.Sessions = append(manifest.Sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate session rows: %w", err)
+	}
+
+	galleries, err := s.GalleryService.ByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("querying galleries: %w", err)
+	}
+
+	for _, gallery := range galleries {
+		images, imagesErr := s.GalleryService.Images(ctx, gallery.ID)
+		if imagesErr != nil {
+			return nil, imagesErr
+		}
+
+		exported := exportGallery{Gallery: gallery, Images: []exportImage{}}
+		for _, image := range images {
+			info, statErr := os.Stat(image.Path)
+			if statErr != nil {
+				return nil, fmt.Errorf("stat image: %w", statErr)
+			}
+			exported.Images = append(exported.Images, exportImage{
+				Filename:   image.Filename,
+				Path:       path.Join("galleries", fmt.Sprintf("gallery_%d", gallery.ID), image.Filename),
+				Size:       info.Size(),
+				ModifiedAt: info.ModTime(),
+			})
+		}
+		manifest.Galleries = append(manifest.Galleries, exported)
+	}
+
+	return &manifest, nil
+}
+
+func (s *DataExportService) hash(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+
+	return base64.URLEncoding.EncodeToString(tokenHash[:])
+}
@@ -1,11 +1,14 @@
 package models
 
 import (
-	"errors"
+	"context"
+	"database/sql"
 	"fmt"
+	"io/fs"
+	"time"
 
 	"github.com/azdanov/imago/config"
-	"github.com/wneessen/go-mail"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -20,83 +23,109 @@ type Email struct {
 	HTML      string
 }
 
+// EmailService queues emails in the Outbox. Queued emails are delivered through the
+// Transport by an EmailDispatcher, so a slow or unavailable mail server never fails
+// the request that sent them.
 type EmailService struct {
+	Outbox EmailOutbox
 	// DefaultSender is the default sender email address when none is provided in the request.
 	// It is used also when an email is predetermined, like in the forgotten password
 	// flow, where the email is not provided by the user.
 	DefaultSender string
-
-	client *mail.Client
+	// MaxAttempts is how often delivery is tried before an email is marked failed.
+	// Defaults to DefaultEmailMaxAttempts.
+	MaxAttempts int
+	// Lease is how long a dispatch may take before its emails are claimed again.
+	// Defaults to DefaultEmailLease.
+	Lease time.Duration
+	// Transport delivers the emails taken from the outbox.
+	Transport EmailTransport
+	// Templates renders the transactional emails.
+	Templates *EmailTemplates
 }
 
-func NewEmailService(cnf *config.Config) (*EmailService, error) {
-	options := []mail.Option{
-		mail.WithPort(cnf.SMTP.Port),
-		mail.WithUsername(cnf.SMTP.Username),
-		mail.WithPassword(cnf.SMTP.Password),
-	}
-
-	if cnf.SMTP.SSLMode {
-		options = append(options, mail.WithTLSPortPolicy(mail.TLSMandatory))
-		options = append(options, mail.WithSMTPAuth(mail.SMTPAuthAutoDiscover))
-	} else {
-		options = append(options, mail.WithTLSPortPolicy(mail.NoTLS))
-		options = append(options, mail.WithSMTPAuth(mail.SMTPAuthPlain))
+// NewEmailService creates an email service with the transport selected in the config.
+// Email templates are read from templatesFS, see EmailTemplates for the layout.
+func NewEmailService(db *sql.DB, cnf *config.Config, templatesFS fs.FS) (*EmailService, error) {
+	transport, err := NewEmailTransport(cnf.SMTP)
+	if err != nil {
+		return nil, fmt.Errorf("email: %w", err)
 	}
 
-	client, err := mail.NewClient(cnf.SMTP.Host, options...)
+	templates, err := ParseEmailTemplates(templatesFS)
 	if err != nil {
 		return nil, fmt.Errorf("email: %w", err)
 	}
 
 	return &EmailService{
+		Outbox:        NewPostgresEmailOutbox(db),
 		DefaultSender: DefaultSender,
-		client:        client,
+		MaxAttempts:   DefaultEmailMaxAttempts,
+		Lease:         DefaultEmailLease,
+		Transport:     transport,
+		Templates:     templates,
 	}, nil
 }
 
-func (e *EmailService) Send(email Email) error {
-	message := mail.NewMsg()
+// Send queues an email for delivery.
+func (e *EmailService) Send(ctx context.Context, email Email) error {
+	return e.Outbox.Queue(ctx, e.Message(email))
+}
 
-	if err := message.From(e.getFrom(email)); err != nil {
-		return fmt.Errorf("send: %w", err)
-	}
+// SendTx queues an email in the email_outbox table as part of tx, so it is only
+// delivered if the surrounding change is committed.
+func (e *EmailService) SendTx(ctx context.Context, tx *sql.Tx, email Email) error {
+	return queueEmail(ctx, tx, e.Message(email))
+}
 
-	if err := message.To(email.To); err != nil {
-		return fmt.Errorf("send: %w", err)
-	}
+// Message prepares an email for the outbox, for stores that queue it themselves
+// like PasswordResetStore.
+func (e *EmailService) Message(email Email) OutboxMessage {
+	email.From = e.getFrom(email)
+	return OutboxMessage{Email: email, MaxAttempts: e.maxAttempts()}
+}
 
-	message.Subject(email.Subject)
-
-	switch {
-	case email.Plaintext != "" && email.HTML != "":
-		message.SetBodyString(mail.TypeTextHTML, email.HTML)
-		message.AddAlternativeString(mail.TypeTextPlain, email.Plaintext)
-	case email.Plaintext != "":
-		message.SetBodyString(mail.TypeTextPlain, email.Plaintext)
-	case email.HTML != "":
-		message.SetBodyString(mail.TypeTextHTML, email.HTML)
-	default:
-		return errors.New("send: no body provided")
-	}
+// Deliver sends an email through the transport right away.
+func (e *EmailService) Deliver(ctx context.Context, email Email) error {
+	email.From = e.getFrom(email)
 
-	if err := e.client.DialAndSend(message); err != nil {
-		return fmt.Errorf("send: %w", err)
+	// The span carries no addresses or subject, so traces never hold personal data.
+	_, span := startSpan(ctx, "email.send",
+		attribute.String("email.transport", fmt.Sprintf("%T", e.Transport)),
+	)
+	err := e.Transport.Send(ctx, email)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("deliver: %w", err)
 	}
 
 	return nil
 }
 
-func (e *EmailService) SendResetPassword(to string, resetURL string) error {
-	email := Email{
-		To:        to,
-		Subject:   "Imago - Reset password",
-		Plaintext: fmt.Sprintf("Click the link to reset your password: %s", resetURL),
-		HTML: fmt.Sprintf("<p>Click the link to reset your password: </p><a href=\"%s\">%s</a>",
-			resetURL, resetURL),
+// DataExportEmail builds the email with the download link of a finished export, for
+// NotificationService.Publish.
+func (e *EmailService) DataExportEmail(to, locale, downloadURL string, expiresAt time.Time) (Email, error) {
+	return e.Render(to, locale, EmailDataExport, DataExportData{
+		DownloadURL: downloadURL,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// ResetPasswordEmail builds the email with a password reset link, for
+// PasswordResetService.Generate to queue together with the reset.
+func (e *EmailService) ResetPasswordEmail(to, locale, resetURL string) (Email, error) {
+	return e.Render(to, locale, EmailResetPassword, ResetPasswordData{ResetURL: resetURL})
+}
+
+// Render builds an email addressed to to from the named template.
+func (e *EmailService) Render(to, locale, name string, data any) (Email, error) {
+	email, err := e.Templates.Render(locale, name, data)
+	if err != nil {
+		return Email{}, err
 	}
+	email.To = to
 
-	return e.Send(email)
+	return email, nil
 }
 
 func (e *EmailService) getFrom(email Email) string {
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/metrics"
+)
+
+const (
+	// DefaultEmailMaxAttempts is how often delivery is tried before an email is marked failed.
+	DefaultEmailMaxAttempts = 8
+	// DefaultSentEmailMaxAge is how long delivered emails are kept in the outbox.
+	DefaultSentEmailMaxAge = 7 * 24 * time.Hour
+	// DefaultEmailDispatchInterval is how often the dispatcher checks for queued emails.
+	DefaultEmailDispatchInterval = 2 * time.Second
+	// DefaultEmailBatchSize is the number of emails delivered per dispatch.
+	DefaultEmailBatchSize = 20
+	// DefaultEmailLease is how long a dispatcher may take to deliver a claimed batch
+	// before other dispatchers claim the emails again.
+	DefaultEmailLease = 5 * time.Minute
+)
+
+type EmailStatus string
+
+const (
+	EmailPending EmailStatus = "pending"
+	// EmailSending is the status of claimed emails, see EmailOutbox.Claim.
+	EmailSending EmailStatus = "sending"
+	EmailSent    EmailStatus = "sent"
+	EmailFailed  EmailStatus = "failed"
+)
+
+// OutboxMessage is an email ready to be queued, see EmailService.Message.
+type OutboxMessage struct {
+	Email
+	// MaxAttempts is how often delivery is tried before the email is marked failed.
+	MaxAttempts int
+}
+
+func (m OutboxMessage) validate() error {
+	if m.Plaintext == "" && m.HTML == "" {
+		return errors.New("queue email: no body provided")
+	}
+	return nil
+}
+
+// OutboxEmail is an email claimed from the outbox for delivery.
+type OutboxEmail struct {
+	Email
+	ID          int64
+	Attempts    int
+	MaxAttempts int
+}
+
+// EmailOutbox holds queued emails until an EmailDispatcher delivers them.
+type EmailOutbox interface {
+	// Queue adds an email for delivery.
+	Queue(ctx context.Context, message OutboxMessage) error
+	// Claim marks up to limit due emails as sending for lease and returns them.
+	// Claimed emails are not returned again until the lease expires, after which an
+	// email whose outcome was never recorded is claimed again.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEmail, error)
+	// MarkSent records the delivery of a claimed email.
+	MarkSent(ctx context.Context, id int64, attempts int) error
+	// MarkRetry returns a claimed email to the queue, to be tried again at next.
+	MarkRetry(ctx context.Context, id int64, attempts int, lastError string, next time.Time) error
+	// MarkFailed gives up on a claimed email.
+	MarkFailed(ctx context.Context, id int64, attempts int, lastError string) error
+	// Count returns the number of emails with status.
+	Count(ctx context.Context, status EmailStatus) (int, error)
+	// DeleteSent removes emails delivered before before.
+	DeleteSent(ctx context.Context, before time.Time) error
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queueEmail inserts message into the email_outbox table through db, which may be a
+// transaction of the change the email is about.
+func queueEmail(ctx context.Context, db execer, message OutboxMessage) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO email_outbox (sender, recipient, subject, plaintext, html, status, max_attempts, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		message.From, message.To, message.Subject, message.Plaintext, message.HTML, EmailPending, message.MaxAttempts,
+		time.Now())
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+
+	return nil
+}
+
+// Dispatch delivers up to limit due emails and records the outcome of each. The
+// emails are claimed for the lease in one short statement, then delivered outside
+// of any transaction, each outcome is recorded on its own. Failed deliveries are
+// retried with exponential backoff until they run out of attempts.
+//
+// Delivery is at least once: an email is sent again when its outcome cannot be
+// recorded, because the process stops or the database fails after delivery, or
+// when the batch takes longer than the lease and another dispatcher claims it.
+func (e *EmailService) Dispatch(ctx context.Context, limit int) (int, error) {
+	emails, err := e.Outbox.Claim(ctx, limit, e.lease())
+	if err != nil {
+		return 0, fmt.Errorf("dispatch emails: %w", err)
+	}
+
+	var errs []error
+	for _, email := range emails {
+		email.Attempts++
+
+		deliverErr := e.Deliver(ctx, email.Email)
+		switch {
+		case deliverErr == nil:
+			metrics.Emails.WithLabelValues("sent").Inc()
+			err = e.Outbox.MarkSent(ctx, email.ID, email.Attempts)
+		case email.Attempts >= email.MaxAttempts:
+			// Like the email.send span, the log holds no addresses.
+			logging.FromContext(ctx).Error("email failed permanently",
+				"email_id", email.ID,
+				"error", deliverErr,
+			)
+			metrics.Emails.WithLabelValues("failed").Inc()
+			err = e.Outbox.MarkFailed(ctx, email.ID, email.Attempts, deliverErr.Error())
+		default:
+			metrics.Emails.WithLabelValues("retry").Inc()
+			next := time.Now().Add(jobBackoff(email.Attempts))
+			err = e.Outbox.MarkRetry(ctx, email.ID, email.Attempts, deliverErr.Error(), next)
+		}
+		// The other emails are delivered already or still can be, so keep going.
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record outcome of email %d: %w", email.ID, err))
+		}
+	}
+
+	return len(emails), errors.Join(errs...)
+}
+
+// DeleteSent removes delivered emails older than maxAge. Failed emails are kept.
+func (e *EmailService) DeleteSent(ctx context.Context, maxAge time.Duration) error {
+	if err := e.Outbox.DeleteSent(ctx, time.Now().Add(-maxAge)); err != nil {
+		return fmt.Errorf("delete sent emails: %w", err)
+	}
+
+	return nil
+}
+
+func (e *EmailService) maxAttempts() int {
+	if e.MaxAttempts <= 0 {
+		return DefaultEmailMaxAttempts
+	}
+	return e.MaxAttempts
+}
+
+func (e *EmailService) lease() time.Duration {
+	if e.Lease <= 0 {
+		return DefaultEmailLease
+	}
+	return e.Lease
+}
+
+// EmailDispatcher delivers queued emails in the background.
+type EmailDispatcher struct {
+	EmailService *EmailService
+	// Interval defaults to DefaultEmailDispatchInterval.
+	Interval time.Duration
+	// BatchSize defaults to DefaultEmailBatchSize.
+	BatchSize int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewEmailDispatcher(es *EmailService) *EmailDispatcher {
+	return &EmailDispatcher{
+		EmailService: es,
+	}
+}
+
+// Start launches the dispatcher. It runs until Shutdown is called.
+func (d *EmailDispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultEmailDispatchInterval
+	}
+	batchSize := d.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEmailBatchSize
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// Keep going while full batches come back, there is probably more waiting.
+			for ctx.Err() == nil {
+				// A batch in progress is finished even when shutdown begins.
+				n, err := d.EmailService.Dispatch(context.WithoutCancel(ctx), batchSize)
+				if err != nil {
+					logging.FromContext(ctx).Error("dispatch emails", "error", err)
+					break
+				}
+				if n < batchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown stops the dispatcher after the batch in progress is delivered.
+func (d *EmailDispatcher) Shutdown(ctx context.Context) error {
+	if d.cancel == nil {
+		return nil
+	}
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown email dispatcher: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,124 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azdanov/imago/models"
+)
+
+// failingTransport fails to deliver to the addresses in fail.
+type failingTransport struct {
+	models.MemoryTransport
+	fail map[string]bool
+}
+
+func (t *failingTransport) Send(ctx context.Context, email models.Email) error {
+	if t.fail[email.To] {
+		return errors.New("mailbox unavailable")
+	}
+	return t.MemoryTransport.Send(ctx, email)
+}
+
+func newDispatchService(t *testing.T, fail ...string) (*models.EmailService, *failingTransport) {
+	t.Helper()
+
+	transport := &failingTransport{fail: map[string]bool{}}
+	for _, to := range fail {
+		transport.fail[to] = true
+	}
+	es := &models.EmailService{
+		Outbox:        models.NewMemoryEmailOutbox(),
+		DefaultSender: models.DefaultSender,
+		MaxAttempts:   2,
+		Transport:     transport,
+	}
+
+	return es, transport
+}
+
+func send(t *testing.T, es *models.EmailService, to ...string) {
+	t.Helper()
+
+	for _, to := range to {
+		if err := es.Send(context.Background(), models.Email{To: to, Subject: "Hi", Plaintext: "Hi"}); err != nil {
+			t.Fatalf("Send(%s) error = %v", to, err)
+		}
+	}
+}
+
+func countEmails(t *testing.T, es *models.EmailService, status models.EmailStatus) int {
+	t.Helper()
+
+	count, err := es.Outbox.Count(context.Background(), status)
+	if err != nil {
+		t.Fatalf("Count(%s) error = %v", status, err)
+	}
+	return count
+}
+
+func TestDispatchBatchWithFailure(t *testing.T) {
+	ctx := context.Background()
+	es, transport := newDispatchService(t, "grace@example.com")
+	send(t, es, "ada@example.com", "grace@example.com", "alan@example.com")
+
+	n, err := es.Dispatch(ctx, 10)
+	if err != nil || n != 3 {
+		t.Fatalf("Dispatch() = %d, %v, want 3 emails", n, err)
+	}
+	if got := len(transport.Emails()); got != 2 {
+		t.Errorf("delivered %d emails, want the 2 that did not fail", got)
+	}
+	if sent := countEmails(t, es, models.EmailSent); sent != 2 {
+		t.Errorf("sent %d emails after Dispatch(), want 2", sent)
+	}
+	if pending := countEmails(t, es, models.EmailPending); pending != 1 {
+		t.Errorf("pending %d emails after Dispatch(), want the failed one", pending)
+	}
+
+	// The failed email waits for its backoff instead of being retried right away.
+	if n, err = es.Dispatch(ctx, 10); err != nil || n != 0 {
+		t.Errorf("Dispatch() during backoff = %d, %v, want none", n, err)
+	}
+}
+
+func TestDispatchFailsPermanently(t *testing.T) {
+	ctx := context.Background()
+	es, transport := newDispatchService(t, "grace@example.com")
+	es.MaxAttempts = 1
+	send(t, es, "grace@example.com")
+
+	if n, err := es.Dispatch(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("Dispatch() = %d, %v, want 1 email", n, err)
+	}
+	if len(transport.Emails()) != 0 {
+		t.Errorf("delivered %v, want nothing", transport.Emails())
+	}
+	if failed := countEmails(t, es, models.EmailFailed); failed != 1 {
+		t.Errorf("failed %d emails, want 1 after its only attempt", failed)
+	}
+}
+
+func TestDispatchAfterLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	es, transport := newDispatchService(t)
+	send(t, es, "ada@example.com", "grace@example.com")
+
+	// A dispatcher claims the first email for an hour, the second for no time at
+	// all, and stops before recording either outcome.
+	if _, err := es.Outbox.Claim(ctx, 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := es.Outbox.Claim(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := es.Dispatch(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("Dispatch() = %d, %v, want only the email with the expired lease", n, err)
+	}
+	if _, ok := transport.Last("grace@example.com"); !ok || len(transport.Emails()) != 1 {
+		t.Errorf("delivered %v, want only the email to grace@example.com", transport.Emails())
+	}
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultLocale is used for users without a locale and for locales that lack a template.
+const DefaultLocale = "en"
+
+// Locales are the languages emails can be sent in, keyed by locale code.
+var Locales = map[string]string{
+	"en": "English",
+	"de": "Deutsch",
+}
+
+// Email template names, one per transactional email.
+const (
+	EmailResetPassword = "reset_password"
+	EmailDataExport    = "data_export"
+	EmailNotification  = "notification"
+)
+
+// ResetPasswordData is rendered by the EmailResetPassword template.
+type ResetPasswordData struct {
+	ResetURL string
+}
+
+// DataExportData is rendered by the EmailDataExport template.
+type DataExportData struct {
+	DownloadURL string
+	ExpiresAt   time.Time
+}
+
+// NotificationData is rendered by the EmailNotification template.
+type NotificationData struct {
+	Message string
+	URL     string
+}
+
+// EmailTemplates renders transactional emails into an HTML and a plain text part.
+// The templates are read from a directory laid out as:
+//
+//	layout.tmpl.html            shared HTML layout
+//	layout.tmpl.txt             shared plain text layout
+//	<locale>/common.tmpl.txt    strings shared by every email, like the footer
+//	<locale>/<name>.tmpl.txt    defines "subject" and the plain text "content"
+//	<locale>/<name>.tmpl.html   defines the HTML "content"
+type EmailTemplates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+func ParseEmailTemplates(fsys fs.FS) (*EmailTemplates, error) {
+	t := EmailTemplates{
+		html: map[string]*htmltemplate.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+
+	for locale := range Locales {
+		files, err := fs.Glob(fsys, path.Join(locale, "*.tmpl.txt"))
+		if err != nil {
+			return nil, fmt.Errorf("parse email templates: %w", err)
+		}
+
+		common := path.Join(locale, "common.tmpl.txt")
+		for _, file := range files {
+			if file == common {
+				continue
+			}
+			name := strings.TrimSuffix(path.Base(file), ".tmpl.txt")
+			key := path.Join(locale, name)
+
+			text, err := texttemplate.New("layout.tmpl.txt").ParseFS(fsys, "layout.tmpl.txt", common, file)
+			if err != nil {
+				return nil, fmt.Errorf("parse email templates: %w", err)
+			}
+			html, err := htmltemplate.New("layout.tmpl.html").
+				ParseFS(fsys, "layout.tmpl.html", common, path.Join(locale, name+".tmpl.html"))
+			if err != nil {
+				return nil, fmt.Errorf("parse email templates: %w", err)
+			}
+
+			t.text[key] = text
+			t.html[key] = html
+		}
+	}
+
+	return &t, nil
+}
+
+// Render executes the named email in the given locale, falling back to DefaultLocale.
+// The returned email has no recipient or sender set.
+func (t *EmailTemplates) Render(locale, name string, data any) (Email, error) {
+	key := path.Join(locale, name)
+	if _, ok := t.text[key]; !ok {
+		key = path.Join(DefaultLocale, name)
+	}
+
+	text, ok := t.text[key]
+	if !ok {
+		return Email{}, fmt.Errorf("render email: unknown template %q", name)
+	}
+	html := t.html[key]
+
+	var subject, plaintext, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Email{}, fmt.Errorf("render email subject: %w", err)
+	}
+	if err := text.Execute(&plaintext, data); err != nil {
+		return Email{}, fmt.Errorf("render email text: %w", err)
+	}
+	if err := html.Execute(&body, data); err != nil {
+		return Email{}, fmt.Errorf("render email html: %w", err)
+	}
+
+	return Email{
+		Subject:   strings.TrimSpace(subject.String()),
+		Plaintext: plaintext.String(),
+		HTML:      body.String(),
+	}, nil
+}
@@ -0,0 +1,125 @@
+package models_test
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/templates"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func parseEmailTemplates(t *testing.T) *models.EmailTemplates {
+	t.Helper()
+
+	emailsFS, err := fs.Sub(templates.FS, "emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := models.ParseEmailTemplates(emailsFS)
+	if err != nil {
+		t.Fatalf("ParseEmailTemplates() error = %v", err)
+	}
+
+	return tmpl
+}
+
+func TestEmailTemplatesGolden(t *testing.T) {
+	tmpl := parseEmailTemplates(t)
+
+	emails := map[string]any{
+		models.EmailResetPassword: models.ResetPasswordData{
+			ResetURL: "http://localhost:3000/reset-password?token=abc&next=%2F",
+		},
+		models.EmailDataExport: models.DataExportData{
+			DownloadURL: "http://localhost:3000/users/me/exports/abc",
+			ExpiresAt:   time.Date(2025, time.March, 14, 9, 30, 0, 0, time.UTC),
+		},
+		models.EmailNotification: models.NotificationData{
+			Message: "Ada commented on <Holidays>",
+			URL:     "http://localhost:3000/galleries/1",
+		},
+	}
+
+	for locale := range models.Locales {
+		for name, data := range emails {
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				email, err := tmpl.Render(locale, name, data)
+				if err != nil {
+					t.Fatalf("Render() error = %v", err)
+				}
+
+				golden := filepath.Join("testdata", "emails", locale+"_"+name)
+				assertGolden(t, golden+".txt.golden", "Subject: "+email.Subject+"\n\n"+email.Plaintext)
+				assertGolden(t, golden+".html.golden", email.HTML)
+			})
+		}
+	}
+}
+
+func TestEmailTemplatesFallbackLocale(t *testing.T) {
+	tmpl := parseEmailTemplates(t)
+	data := models.ResetPasswordData{ResetURL: "http://localhost:3000/reset-password?token=abc"}
+
+	want, err := tmpl.Render(models.DefaultLocale, models.EmailResetPassword, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	got, err := tmpl.Render("xx", models.EmailResetPassword, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if got != want {
+		t.Errorf("Render() with unknown locale = %+v, want the %v email %+v", got, models.DefaultLocale, want)
+	}
+}
+
+func TestEmailTemplatesUnknownName(t *testing.T) {
+	tmpl := parseEmailTemplates(t)
+
+	if _, err := tmpl.Render(models.DefaultLocale, "missing", nil); err == nil {
+		t.Error("Render() with unknown template error = nil, want error")
+	}
+}
+
+func TestEmailTemplatesEscapeHTML(t *testing.T) {
+	tmpl := parseEmailTemplates(t)
+	data := models.ResetPasswordData{ResetURL: `http://localhost:3000/"><script>alert(1)</script>`}
+
+	email, err := tmpl.Render(models.DefaultLocale, models.EmailResetPassword, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if strings.Contains(email.HTML, "<script>") {
+		t.Errorf("Render() HTML contains unescaped script tag:\n%v", email.HTML)
+	}
+}
+
+func assertGolden(t *testing.T, path, got string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, run with -update to create it: %v", err)
+	}
+
+	if got != string(want) {
+		t.Errorf("%v mismatch, run with -update to accept\ngot:\n%v\nwant:\n%v", path, got, want)
+	}
+}
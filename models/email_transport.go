@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/rand"
+	"github.com/wneessen/go-mail"
+)
+
+// EmailTransport delivers a single email. The sender is always set by the caller.
+type EmailTransport interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// NewEmailTransport returns the transport selected by cnf.Transport.
+func NewEmailTransport(cnf config.SMTPConfig) (EmailTransport, error) {
+	switch cnf.Transport {
+	case config.SMTPTransportSMTP, "":
+		return NewSMTPTransport(cnf)
+	case config.SMTPTransportFile:
+		return &FileTransport{Dir: cnf.FileDir}, nil
+	case config.SMTPTransportLog:
+		return &LogTransport{}, nil
+	case config.SMTPTransportMemory:
+		return &MemoryTransport{}, nil
+	default:
+		return nil, fmt.Errorf("email transport: unknown transport %q", cnf.Transport)
+	}
+}
+
+// SMTPTransport sends emails through an SMTP server.
+type SMTPTransport struct {
+	client *mail.Client
+}
+
+func NewSMTPTransport(cnf config.SMTPConfig) (*SMTPTransport, error) {
+	options := []mail.Option{
+		mail.WithPort(cnf.Port),
+		mail.WithUsername(cnf.Username),
+		mail.WithPassword(cnf.Password),
+	}
+
+	if cnf.SSLMode {
+		options = append(options, mail.WithTLSPortPolicy(mail.TLSMandatory))
+		options = append(options, mail.WithSMTPAuth(mail.SMTPAuthAutoDiscover))
+	} else {
+		options = append(options, mail.WithTLSPortPolicy(mail.NoTLS))
+		options = append(options, mail.WithSMTPAuth(mail.SMTPAuthPlain))
+	}
+
+	client, err := mail.NewClient(cnf.Host, options...)
+	if err != nil {
+		return nil, fmt.Errorf("smtp transport: %w", err)
+	}
+
+	return &SMTPTransport{client: client}, nil
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, email Email) error {
+	message, err := newMessage(email)
+	if err != nil {
+		return err
+	}
+
+	if err = t.client.DialAndSendWithContext(ctx, message); err != nil {
+		return fmt.Errorf("smtp transport: %w", err)
+	}
+
+	return nil
+}
+
+// FileTransport writes every email as an .eml file, which can be opened with any
+// mail client. Useful in development when no SMTP server is running.
+type FileTransport struct {
+	// Dir defaults to "mail".
+	Dir string
+}
+
+func (t *FileTransport) Send(_ context.Context, email Email) error {
+	message, err := newMessage(email)
+	if err != nil {
+		return err
+	}
+
+	dir := t.Dir
+	if dir == "" {
+		dir = "mail"
+	}
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	suffix, err := rand.Bytes(4)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	name := time.Now().Format("20060102T150405") + "_" + hex.EncodeToString(suffix) + ".eml"
+
+	if err = message.WriteToFile(filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	return nil
+}
+
+// LogTransport writes emails to the logger instead of sending them.
+type LogTransport struct{}
+
+func (t *LogTransport) Send(ctx context.Context, email Email) error {
+	body := email.Plaintext
+	if body == "" {
+		body = email.HTML
+	}
+	logging.FromContext(ctx).Info("email",
+		"from", email.From,
+		"to", email.To,
+		"subject", email.Subject,
+		"body", body,
+	)
+	return nil
+}
+
+// MemoryTransport keeps sent emails in memory so tests can inspect them.
+type MemoryTransport struct {
+	mu     sync.Mutex
+	emails []Email
+}
+
+func (t *MemoryTransport) Send(_ context.Context, email Email) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emails = append(t.emails, email)
+	return nil
+}
+
+// Emails returns a copy of every email sent so far.
+func (t *MemoryTransport) Emails() []Email {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Email(nil), t.emails...)
+}
+
+// Last returns the most recent email sent to the given address.
+func (t *MemoryTransport) Last(to string) (Email, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := len(t.emails) - 1; i >= 0; i-- {
+		if t.emails[i].To == to {
+			return t.emails[i], true
+		}
+	}
+	return Email{}, false
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emails = nil
+}
+
+func newMessage(email Email) (*mail.Msg, error) {
+	message := mail.NewMsg()
+
+	if err := message.From(email.From); err != nil {
+		return nil, fmt.Errorf("build message: %w", err)
+	}
+
+	if err := message.To(email.To); err != nil {
+		return nil, fmt.Errorf("build message: %w", err)
+	}
+
+	message.Subject(email.Subject)
+
+	switch {
+	case email.Plaintext != "" && email.HTML != "":
+		message.SetBodyString(mail.TypeTextHTML, email.HTML)
+		message.AddAlternativeString(mail.TypeTextPlain, email.Plaintext)
+	case email.Plaintext != "":
+		message.SetBodyString(mail.TypeTextPlain, email.Plaintext)
+	case email.HTML != "":
+		message.SetBodyString(mail.TypeTextHTML, email.HTML)
+	default:
+		return nil, errors.New("build message: no body provided")
+	}
+
+	return message, nil
+}
@@ -12,6 +12,9 @@ import (
 var (
 	ErrEmailAlreadyExists = errors.New("models: email already exists")
 	ErrNotFound           = errors.New("models: not found")
+	ErrAccountDisabled    = errors.New("models: account disabled")
+	ErrUnsupportedLocale  = errors.New("models: unsupported locale")
+	ErrDeletionNotDue     = errors.New("models: account deletion cancelled or not due")
 )
 
 type FileError struct {
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/azdanov/imago/logging"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before further
+// events are dropped for it.
+const eventBufferSize = 16
+
+// Event types published by the services.
+const (
+	EventGalleryCreated      = "gallery.created"
+	EventGalleryUpdated      = "gallery.updated"
+	EventGalleryDeleted      = "gallery.deleted"
+	EventImageCreated        = "image.created"
+	EventImageDeleted        = "image.deleted"
+	EventNotificationCreated = "notification.created"
+)
+
+// Event is a change that live pages may want to show without reloading.
+type Event struct {
+	// ID is assigned by the broker that delivers the event and increases with every
+	// event it delivers.
+	ID    int64           `json:"id"`
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// GalleryTopic is the topic for changes to a gallery and its images.
+func GalleryTopic(galleryID int) string {
+	return "gallery:" + strconv.Itoa(galleryID)
+}
+
+// UserTopic is the topic for changes that concern a single user.
+func UserTopic(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// EventRelay carries events between server instances. Notify must eventually hand
+// the event to Broadcast on every broker, including the one that published it.
+type EventRelay interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// EventBroker is an in-process pub/sub for Events. Delivery is best effort: a
+// subscriber that does not keep up misses events instead of slowing down publishers.
+//
+// A nil *EventBroker is valid: it discards everything published to it, and its
+// subscriptions are closed right away.
+type EventBroker struct {
+	// Relay, when set, is used to publish events, so subscribers connected to other
+	// instances receive them too.
+	Relay EventRelay
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan Event]struct{}
+	lastID      int64
+	closed      bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: map[string]map[chan Event]struct{}{},
+	}
+}
+
+// Publish sends an event with the JSON encoded data to the subscribers of topic.
+// Errors are logged, because a missed live update is never worth failing the change
+// that caused it.
+func (b *EventBroker) Publish(ctx context.Context, topic, eventType string, data any) {
+	if b == nil {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logging.FromContext(ctx).Error("publish event", "type", eventType, "error", err)
+		return
+	}
+
+	event := Event{Topic: topic, Type: eventType, Data: payload}
+	if b.Relay == nil {
+		b.Broadcast(event)
+		return
+	}
+
+	if err = b.Relay.Notify(ctx, event); err != nil {
+		logging.FromContext(ctx).Error("publish event", "type", eventType, "error", err)
+		// Subscribers on this instance should still see the change.
+		b.Broadcast(event)
+	}
+}
+
+// Broadcast delivers an event to the local subscribers of its topic.
+func (b *EventBroker) Broadcast(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.lastID++
+	event.ID = b.lastID
+
+	for ch := range b.subscribers[event.Topic] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events published to topic and a function
+// that ends the subscription. The channel is closed when the subscription ends or the
+// broker is closed.
+func (b *EventBroker) Subscribe(topic string) (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	if b == nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan Event]struct{}{}
+	}
+	b.subscribers[topic][ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if _, ok := b.subscribers[topic][ch]; !ok {
+				return
+			}
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Close ends all subscriptions, which lets open event streams finish.
+func (b *EventBroker) Close() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for topic, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(b.subscribers, topic)
+	}
+}
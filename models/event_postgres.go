@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/azdanov/imago/logging"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// eventChannel is the Postgres notification channel carrying events.
+	eventChannel = "imago_events"
+	// eventListenRetryDelay is how long the listener waits before reconnecting.
+	eventListenRetryDelay = 5 * time.Second
+)
+
+// PostgresEventRelay shares events between server instances through Postgres
+// LISTEN/NOTIFY. Events published while a listener reconnects are lost, like any
+// event sent to a subscriber that is not connected.
+type PostgresEventRelay struct {
+	DB *sql.DB
+	// DSN is used to open the dedicated connection that listens for notifications.
+	DSN    string
+	Broker *EventBroker
+}
+
+func NewPostgresEventRelay(db *sql.DB, dsn string, broker *EventBroker) *PostgresEventRelay {
+	return &PostgresEventRelay{
+		DB:     db,
+		DSN:    dsn,
+		Broker: broker,
+	}
+}
+
+func (r *PostgresEventRelay) Notify(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("notify event: %w", err)
+	}
+
+	if _, err = r.DB.ExecContext(ctx, `SELECT pg_notify($1, $2);`, eventChannel, string(payload)); err != nil {
+		return fmt.Errorf("notify event: %w", err)
+	}
+
+	return nil
+}
+
+// Listen broadcasts the events received from Postgres until ctx is done. A lost
+// connection is reopened after eventListenRetryDelay.
+func (r *PostgresEventRelay) Listen(ctx context.Context) {
+	for {
+		err := r.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.FromContext(ctx).Error("listen for events", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventListenRetryDelay):
+		}
+	}
+}
+
+func (r *PostgresEventRelay) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, r.DSN)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "LISTEN "+eventChannel); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+
+		var event Event
+		if err = json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			logging.FromContext(ctx).Error("decode event", "error", err)
+			continue
+		}
+		r.Broker.Broadcast(event)
+	}
+}
@@ -0,0 +1,155 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/azdanov/imago/models"
+)
+
+// receive returns the next event on ch, or fails when none is waiting.
+func receive(t *testing.T, ch <-chan models.Event) models.Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed, want an event")
+		}
+		return event
+	default:
+		t.Fatal("no event received")
+		return models.Event{}
+	}
+}
+
+func assertNoEvent(t *testing.T, ch <-chan models.Event) {
+	t.Helper()
+
+	select {
+	case event, ok := <-ch:
+		if ok {
+			t.Errorf("received %+v, want no event", event)
+		}
+	default:
+	}
+}
+
+func assertClosed(t *testing.T, ch <-chan models.Event) {
+	t.Helper()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("received an event, want the channel closed")
+		}
+	default:
+		t.Error("channel open, want it closed")
+	}
+}
+
+func TestEventBrokerTopics(t *testing.T) {
+	ctx := context.Background()
+	b := models.NewEventBroker()
+	gallery, unsubscribe := b.Subscribe(models.GalleryTopic(1))
+	defer unsubscribe()
+	other, unsubscribeOther := b.Subscribe(models.GalleryTopic(2))
+	defer unsubscribeOther()
+
+	b.Publish(ctx, models.GalleryTopic(1), models.EventGalleryUpdated, map[string]string{"title": "Holidays"})
+	b.Publish(ctx, models.GalleryTopic(1), models.EventImageCreated, map[string]string{"filename": "beach.png"})
+
+	first, second := receive(t, gallery), receive(t, gallery)
+	if first.Type != models.EventGalleryUpdated || string(first.Data) != `{"title":"Holidays"}` {
+		t.Errorf("first event = %+v, want the gallery update", first)
+	}
+	if second.Type != models.EventImageCreated || second.ID <= first.ID {
+		t.Errorf("second event = %+v, want the new image with an ID after %d", second, first.ID)
+	}
+	assertNoEvent(t, other)
+}
+
+func TestEventBrokerDropsForSlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	b := models.NewEventBroker()
+	events, unsubscribe := b.Subscribe(models.UserTopic(1))
+	defer unsubscribe()
+
+	// More events than the subscriber buffers, Publish must not block.
+	const published = 100
+	for i := range published {
+		b.Publish(ctx, models.UserTopic(1), models.EventNotificationCreated, i)
+	}
+
+	received := 0
+	for len(events) > 0 {
+		<-events
+		received++
+	}
+	if received == 0 || received >= published {
+		t.Errorf("received %d of %d events, want the overflow dropped", received, published)
+	}
+}
+
+func TestEventBrokerUnsubscribeAndClose(t *testing.T) {
+	b := models.NewEventBroker()
+	first, unsubscribe := b.Subscribe(models.UserTopic(1))
+	second, _ := b.Subscribe(models.UserTopic(1))
+
+	unsubscribe()
+	unsubscribe()
+	assertClosed(t, first)
+
+	b.Publish(context.Background(), models.UserTopic(1), models.EventNotificationCreated, nil)
+	receive(t, second)
+
+	b.Close()
+	assertClosed(t, second)
+
+	late, _ := b.Subscribe(models.UserTopic(1))
+	assertClosed(t, late)
+}
+
+func TestEventBrokerNil(t *testing.T) {
+	var b *models.EventBroker
+	b.Publish(context.Background(), models.UserTopic(1), models.EventNotificationCreated, nil)
+	b.Broadcast(models.Event{Topic: models.UserTopic(1)})
+	events, unsubscribe := b.Subscribe(models.UserTopic(1))
+	unsubscribe()
+	assertClosed(t, events)
+	b.Close()
+}
+
+// fakeRelay records the events it is asked to relay and returns err.
+type fakeRelay struct {
+	events []models.Event
+	err    error
+}
+
+func (r *fakeRelay) Notify(_ context.Context, event models.Event) error {
+	r.events = append(r.events, event)
+	return r.err
+}
+
+func TestEventBrokerRelay(t *testing.T) {
+	ctx := context.Background()
+	relay := &fakeRelay{}
+	b := models.NewEventBroker()
+	b.Relay = relay
+	events, unsubscribe := b.Subscribe(models.UserTopic(1))
+	defer unsubscribe()
+
+	// The relay hands the event back to Broadcast, so it is not delivered twice.
+	b.Publish(ctx, models.UserTopic(1), models.EventNotificationCreated, nil)
+	if len(relay.events) != 1 {
+		t.Fatalf("relayed %d events, want 1", len(relay.events))
+	}
+	assertNoEvent(t, events)
+
+	relay.err = errors.New("relay down")
+	b.Publish(ctx, models.UserTopic(1), models.EventNotificationCreated, nil)
+	if event := receive(t, events); event.Type != models.EventNotificationCreated {
+		t.Errorf("event = %+v, want it delivered locally when the relay fails", event)
+	}
+}
@@ -1,7 +1,7 @@
 package models
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +10,9 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/azdanov/imago/metrics"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Gallery struct {
@@ -25,101 +28,136 @@ type Image struct {
 	Filename  string
 }
 
+// galleryEvent is the data of gallery and image events that carry no full Gallery.
+type galleryEvent struct {
+	GalleryID int    `json:"gallery_id"`
+	Filename  string `json:"filename,omitempty"`
+}
+
+// GalleryStore persists galleries. The images of a gallery are kept on disk by the
+// GalleryService, not by the store.
+type GalleryStore interface {
+	// Create stores a new gallery and sets its ID.
+	Create(ctx context.Context, gallery *Gallery) error
+	// ByID returns the gallery with the ID, or ErrNotFound.
+	ByID(ctx context.Context, id int) (*Gallery, error)
+	ByUserID(ctx context.Context, userID int) ([]Gallery, error)
+	// All returns every gallery ordered by ID.
+	All(ctx context.Context) ([]Gallery, error)
+	// Update saves the title of the gallery.
+	Update(ctx context.Context, gallery *Gallery) error
+	// Delete removes the gallery and returns the ID of its owner, or ErrNotFound.
+	Delete(ctx context.Context, id int) (int, error)
+}
+
 type GalleryService struct {
-	DB       *sql.DB
+	Store    GalleryStore
 	ImageDir string
+	// Events receives an event for every change to a gallery or its images. It may be nil.
+	Events *EventBroker
 }
 
-func NewGalleryService(db *sql.DB) *GalleryService {
+func NewGalleryService(store GalleryStore, eb *EventBroker) *GalleryService {
 	return &GalleryService{
-		DB: db,
+		Store:  store,
+		Events: eb,
 	}
 }
 
-func (s *GalleryService) Create(title string, userID int) (*Gallery, error) {
+func (s *GalleryService) Create(ctx context.Context, title string, userID int) (*Gallery, error) {
 	gallery := Gallery{
 		Title:     title,
 		UserID:    userID,
 		CreatedAt: time.Now(),
 	}
 
-	row := s.DB.QueryRow(`INSERT INTO galleries (title, user_id, created_at) VALUES ($1, $2, $3) RETURNING id;`,
-		gallery.Title, gallery.UserID, gallery.CreatedAt)
-
-	err := row.Scan(&gallery.ID)
-	if err != nil {
+	if err := s.Store.Create(ctx, &gallery); err != nil {
 		return nil, fmt.Errorf("create gallery: %w", err)
 	}
 
+	s.Events.Publish(ctx, UserTopic(gallery.UserID), EventGalleryCreated, gallery)
+
 	return &gallery, nil
 }
 
-func (s *GalleryService) ByID(id int) (*Gallery, error) {
-	gallery := Gallery{}
-
-	query := `SELECT id, user_id, title, created_at FROM galleries WHERE id = $1;`
-	err := s.DB.QueryRow(query, id).Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.CreatedAt)
+func (s *GalleryService) ByID(ctx context.Context, id int) (*Gallery, error) {
+	gallery, err := s.Store.ByID(ctx, id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("query gallery by id: %w", err)
 	}
 
-	return &gallery, nil
+	return gallery, nil
 }
 
-func (s *GalleryService) ByUserID(userID int) ([]Gallery, error) {
-	query := `SELECT id, user_id, title, created_at FROM galleries WHERE user_id = $1;`
-	rows, err := s.DB.Query(query, userID)
+func (s *GalleryService) ByUserID(ctx context.Context, userID int) ([]Gallery, error) {
+	galleries, err := s.Store.ByUserID(ctx, userID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("query galleries by user id: %w", err)
 	}
-	defer rows.Close()
 
-	var galleries []Gallery
+	return galleries, nil
+}
 
-	for rows.Next() {
-		var gallery Gallery
-		err = rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("scan gallery row: %w", err)
-		}
-		galleries = append(galleries, gallery)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate gallery rows: %w", err)
+// All returns every gallery regardless of owner, ordered by ID.
+func (s *GalleryService) All(ctx context.Context) ([]Gallery, error) {
+	galleries, err := s.Store.All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query all galleries: %w", err)
 	}
 
 	return galleries, nil
 }
 
-func (s *GalleryService) Update(gallery *Gallery) error {
-	query := `UPDATE galleries SET title = $1 WHERE id = $2;`
-	_, err := s.DB.Exec(query, gallery.Title, gallery.ID)
-	if err != nil {
+func (s *GalleryService) Update(ctx context.Context, gallery *Gallery) error {
+	if err := s.Store.Update(ctx, gallery); err != nil {
 		return fmt.Errorf("update gallery: %w", err)
 	}
 
+	s.Events.Publish(ctx, GalleryTopic(gallery.ID), EventGalleryUpdated, gallery)
+	s.Events.Publish(ctx, UserTopic(gallery.UserID), EventGalleryUpdated, gallery)
+
 	return nil
 }
 
-func (s *GalleryService) Delete(id int) error {
+func (s *GalleryService) Delete(ctx context.Context, id int) error {
 	galleryDir := s.galleryDir(id)
+	_, span := startSpan(ctx, "storage.remove_all", attribute.String("storage.path", galleryDir))
 	err := os.RemoveAll(galleryDir)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("delete gallery images: %w", err)
 	}
 
-	query := `DELETE FROM galleries WHERE id = $1;`
-	_, err = s.DB.Exec(query, id)
+	userID, err := s.Store.Delete(ctx, id)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
 		return fmt.Errorf("delete gallery: %w", err)
 	}
 
+	event := galleryEvent{GalleryID: id}
+	s.Events.Publish(ctx, GalleryTopic(id), EventGalleryDeleted, event)
+	s.Events.Publish(ctx, UserTopic(userID), EventGalleryDeleted, event)
+
+	return nil
+}
+
+// removeDeleted removes the image files of a gallery whose row was already deleted,
+// like by AccountDeletionService.Purge, and announces the deletion.
+func (s *GalleryService) removeDeleted(ctx context.Context, gallery Gallery) error {
+	galleryDir := s.galleryDir(gallery.ID)
+	_, span := startSpan(ctx, "storage.remove_all", attribute.String("storage.path", galleryDir))
+	err := os.RemoveAll(galleryDir)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("delete gallery images: %w", err)
+	}
+
+	event := galleryEvent{GalleryID: gallery.ID}
+	s.Events.Publish(ctx, GalleryTopic(gallery.ID), EventGalleryDeleted, event)
+	s.Events.Publish(ctx, UserTopic(gallery.UserID), EventGalleryDeleted, event)
+
 	return nil
 }
 
@@ -127,12 +165,16 @@ func (s *GalleryService) Extensions() []string {
 	return []string{".png", ".jpg", ".jpeg", ".gif"}
 }
 
-func (s *GalleryService) galleryDir(id int) string {
-	imagesDir := s.ImageDir
-	if imagesDir == "" {
-		imagesDir = "images"
+// ImagesDir is the directory holding the images of all galleries.
+func (s *GalleryService) ImagesDir() string {
+	if s.ImageDir == "" {
+		return "images"
 	}
-	return filepath.Join(imagesDir, fmt.Sprintf("gallery_%d", id))
+	return s.ImageDir
+}
+
+func (s *GalleryService) galleryDir(id int) string {
+	return filepath.Join(s.ImagesDir(), fmt.Sprintf("gallery_%d", id))
 }
 
 func hasExtension(file string, extensions []string) bool {
@@ -146,13 +188,17 @@ func hasExtension(file string, extensions []string) bool {
 	return false
 }
 
+// imageContentTypes are the types http.DetectContentType reports for the Extensions.
+// It reports JPEG files as image/jpeg.
 func (s *GalleryService) imageContentTypes() []string {
-	return []string{"image/png", "image/jpg", "image/gif"}
+	return []string{"image/png", "image/jpeg", "image/jpg", "image/gif"}
 }
 
-func (s *GalleryService) Images(galleryID int) ([]Image, error) {
+func (s *GalleryService) Images(ctx context.Context, galleryID int) ([]Image, error) {
 	globPattern := filepath.Join(s.galleryDir(galleryID), "*")
+	_, span := startSpan(ctx, "storage.glob", attribute.String("storage.path", globPattern))
 	allFiles, err := filepath.Glob(globPattern)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving gallery images: %w", err)
 	}
@@ -170,14 +216,18 @@ func (s *GalleryService) Images(galleryID int) ([]Image, error) {
 	return images, nil
 }
 
-func (s *GalleryService) Image(galleryID int, filename string) (Image, error) {
+func (s *GalleryService) Image(ctx context.Context, galleryID int, filename string) (Image, error) {
 	imagePath := filepath.Clean(filepath.Join(s.galleryDir(galleryID), filename))
 
+	_, span := startSpan(ctx, "storage.stat", attribute.String("storage.path", imagePath))
 	_, err := os.Stat(imagePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// A missing image is an expected outcome, not a storage failure.
+		endSpan(span, nil)
+		return Image{}, ErrNotFound
+	}
+	endSpan(span, err)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return Image{}, ErrNotFound
-		}
 		return Image{}, fmt.Errorf("querying for image: %w", err)
 	}
 
@@ -190,19 +240,32 @@ func (s *GalleryService) Image(galleryID int, filename string) (Image, error) {
 	return image, nil
 }
 
-func (s *GalleryService) DeleteImage(galleryID int, filename string) error {
-	image, err := s.Image(galleryID, filename)
+func (s *GalleryService) DeleteImage(ctx context.Context, galleryID int, filename string) error {
+	image, err := s.Image(ctx, galleryID, filename)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
+	_, span := startSpan(ctx, "storage.remove", attribute.String("storage.path", image.Path))
 	err = os.Remove(image.Path)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
+
+	s.Events.Publish(ctx, GalleryTopic(galleryID), EventImageDeleted, galleryEvent{
+		GalleryID: galleryID,
+		Filename:  image.Filename,
+	})
+
 	return nil
 }
 
-func (s *GalleryService) CreateImage(galleryID int, filename string, contents io.ReadSeeker) error {
+func (s *GalleryService) CreateImage(
+	ctx context.Context,
+	galleryID int,
+	filename string,
+	contents io.ReadSeeker,
+) error {
 	err := checkContentType(contents, s.imageContentTypes())
 	if err != nil {
 		return fmt.Errorf("creating image %v: %w", filename, err)
@@ -214,25 +277,111 @@ func (s *GalleryService) CreateImage(galleryID int, filename string, contents io
 	}
 
 	galleryDir := s.galleryDir(galleryID)
+	imagePath := filepath.Clean(filepath.Join(galleryDir, filename))
 
-	err = os.MkdirAll(galleryDir, 0o750)
+	_, span := startSpan(ctx, "storage.write", attribute.String("storage.path", imagePath))
+	n, err := writeImage(galleryDir, imagePath, contents)
+	span.SetAttributes(attribute.Int64("storage.bytes", n))
+	endSpan(span, err)
 	if err != nil {
-		return fmt.Errorf("creating gallery-%d images directory: %w", galleryID, err)
+		return err
 	}
 
-	imagePath := filepath.Clean(filepath.Join(galleryDir, filename))
+	metrics.ImageUploads.Inc()
+	metrics.ImageUploadBytes.Add(float64(n))
 
-	dst, err := os.Create(imagePath)
+	s.Events.Publish(ctx, GalleryTopic(galleryID), EventImageCreated, galleryEvent{
+		GalleryID: galleryID,
+		Filename:  filepath.Base(imagePath),
+	})
+
+	return nil
+}
+
+// writeImage stores contents at imagePath, creating the gallery directory dir first.
+func writeImage(dir, imagePath string, contents io.Reader) (int64, error) {
+	err := os.MkdirAll(dir, 0o750)
 	if err != nil {
-		return fmt.Errorf("creating image file: %w", err)
+		return 0, fmt.Errorf("creating %s images directory: %w", filepath.Base(dir), err)
 	}
 
+	dst, err := os.Create(imagePath)
+	if err != nil {
+		return 0, fmt.Errorf("creating image file: %w", err)
+	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, contents)
+	n, err := io.Copy(dst, contents)
 	if err != nil {
-		return fmt.Errorf("copying contents to image: %w", err)
+		return n, fmt.Errorf("copying contents to image: %w", err)
 	}
 
-	return nil
+	return n, nil
+}
+
+// ImageProblem is a file in the images directory that is not a servable image.
+type ImageProblem struct {
+	Path  string
+	Issue string
+}
+
+// VerifyImages checks every file in the images directory. Each must be in the directory
+// of an existing gallery and pass the checks of an upload.
+func (s *GalleryService) VerifyImages(ctx context.Context) ([]ImageProblem, error) {
+	galleries, err := s.Store.All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("verify images: %w", err)
+	}
+	exists := make(map[string]bool, len(galleries))
+	for _, gallery := range galleries {
+		exists[filepath.Base(s.galleryDir(gallery.ID))] = true
+	}
+
+	entries, err := os.ReadDir(s.ImagesDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("verify images: %w", err)
+	}
+
+	var problems []ImageProblem
+	for _, entry := range entries {
+		dir := filepath.Join(s.ImagesDir(), entry.Name())
+		if !entry.IsDir() {
+			problems = append(problems, ImageProblem{Path: dir, Issue: "not in a gallery directory"})
+			continue
+		}
+		if !exists[entry.Name()] {
+			problems = append(problems, ImageProblem{Path: dir, Issue: "gallery does not exist"})
+			continue
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("verify images: %w", err)
+		}
+		for _, file := range files {
+			imagePath := filepath.Join(dir, file.Name())
+			if err = s.verifyImage(imagePath); err != nil {
+				problems = append(problems, ImageProblem{Path: imagePath, Issue: err.Error()})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+func (s *GalleryService) verifyImage(imagePath string) error {
+	if err := checkExtension(imagePath, s.Extensions()); err != nil {
+		return err
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return checkContentType(f, s.imageContentTypes())
 }
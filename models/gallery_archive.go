@@ -0,0 +1,83 @@
+package models
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const galleryManifestName = "manifest.json"
+
+type galleryManifest struct {
+	ID          int                    `json:"id"`
+	Title       string                 `json:"title"`
+	CreatedAt   time.Time              `json:"created_at"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Images      []galleryManifestImage `json:"images"`
+}
+
+type galleryManifestImage struct {
+	Filename string `json:"filename"`
+	// Original is the filename in the gallery, which differs from Filename when the
+	// name had to be deduplicated inside the archive.
+	Original string `json:"original"`
+}
+
+// WriteArchive streams a ZIP archive with every image of the gallery to w. Entries are
+// written one at a time, so the archive is never held in memory. If withManifest is
+// set, a JSON manifest describing the gallery is added at the end.
+func (s *GalleryService) WriteArchive(ctx context.Context, w io.Writer, gallery *Gallery, withManifest bool) error {
+	images, err := s.Images(ctx, gallery.ID)
+	if err != nil {
+		return fmt.Errorf("write gallery archive: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+
+	manifest := galleryManifest{
+		ID:          gallery.ID,
+		Title:       gallery.Title,
+		CreatedAt:   gallery.CreatedAt,
+		GeneratedAt: time.Now(),
+		Images:      []galleryManifestImage{},
+	}
+
+	seen := map[string]bool{}
+	if withManifest {
+		// Reserve the manifest name so an image can never shadow it.
+		seen[galleryManifestName] = true
+	}
+
+	for _, image := range images {
+		name := uniqueName(image.Filename, seen)
+		if err = addFileToZip(zw, name, image.Path); err != nil {
+			return fmt.Errorf("write gallery archive: %w", err)
+		}
+		manifest.Images = append(manifest.Images, galleryManifestImage{
+			Filename: name,
+			Original: image.Filename,
+		})
+	}
+
+	if withManifest {
+		var mw io.Writer
+		mw, err = zw.Create(galleryManifestName)
+		if err != nil {
+			return fmt.Errorf("write gallery archive: %w", err)
+		}
+		enc := json.NewEncoder(mw)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(manifest); err != nil {
+			return fmt.Errorf("write gallery archive: %w", err)
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("write gallery archive: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits applied to ZIP imports. They guard against archives that expand far beyond
+// their upload size (zip bombs) or that contain an unreasonable number of entries.
+const (
+	MaxImportEntries          = 500
+	MaxImportEntrySize        = 5 << 20   // 5 MB, same as a single upload
+	MaxImportUncompressedSize = 500 << 20 // 500 MB
+	MaxImportCompressionRatio = 100
+)
+
+var ErrImportLimit = errors.New("models: import exceeds limits")
+
+// ImportResult describes the outcome for a single archive entry.
+type ImportResult struct {
+	// Name is the entry name inside the archive.
+	Name string
+	// Filename is the name the image was stored under, empty on failure.
+	Filename string
+	Err      error
+}
+
+// ImportZip unpacks the images in a ZIP archive into the gallery. Archive level problems,
+// like an unreadable archive or exceeded limits, are returned as an error before anything
+// is written. Problems with individual entries are reported in the results instead, so
+// one bad file does not abort the rest of the import.
+func (s *GalleryService) ImportZip(
+	ctx context.Context,
+	galleryID int,
+	r io.ReaderAt,
+	size int64,
+) ([]ImportResult, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("import zip: %w", err)
+	}
+
+	var entries []*zip.File
+	var totalSize uint64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isArchiveMetadata(f.Name) {
+			continue
+		}
+		entries = append(entries, f)
+		totalSize += f.UncompressedSize64
+	}
+
+	if len(entries) > MaxImportEntries {
+		return nil, fmt.Errorf("import zip: %w: %d entries, at most %d allowed",
+			ErrImportLimit, len(entries), MaxImportEntries)
+	}
+	if totalSize > MaxImportUncompressedSize {
+		return nil, fmt.Errorf("import zip: %w: %d bytes uncompressed, at most %d allowed",
+			ErrImportLimit, totalSize, MaxImportUncompressedSize)
+	}
+
+	// Entries get new names instead of replacing images already in the gallery.
+	existing, err := s.Images(ctx, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("import zip: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, image := range existing {
+		seen[strings.ToLower(image.Filename)] = true
+	}
+
+	results := make([]ImportResult, 0, len(entries))
+
+	for _, f := range entries {
+		result := ImportResult{Name: f.Name}
+
+		filename, entryErr := s.importEntry(ctx, galleryID, f, seen)
+		if entryErr != nil {
+			result.Err = entryErr
+		} else {
+			result.Filename = filename
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *GalleryService) importEntry(
+	ctx context.Context,
+	galleryID int,
+	f *zip.File,
+	seen map[string]bool,
+) (string, error) {
+	name, err := safeEntryName(f.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if f.UncompressedSize64 > MaxImportEntrySize {
+		return "", FileError{Issue: fmt.Sprintf("file is larger than %d bytes", MaxImportEntrySize)}
+	}
+	if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > MaxImportCompressionRatio {
+		return "", FileError{Issue: "suspicious compression ratio"}
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("opening %v: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	// The header sizes can lie, so the actual bytes read are limited as well.
+	contents, err := io.ReadAll(io.LimitReader(rc, MaxImportEntrySize+1))
+	if err != nil {
+		return "", fmt.Errorf("reading %v: %w", f.Name, err)
+	}
+	if len(contents) > MaxImportEntrySize {
+		return "", FileError{Issue: fmt.Sprintf("file is larger than %d bytes", MaxImportEntrySize)}
+	}
+
+	filename := uniqueName(name, seen)
+	if err = s.CreateImage(ctx, galleryID, filename, bytes.NewReader(contents)); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// safeEntryName returns the base name of an archive entry. Entries with absolute paths
+// or parent directory references are rejected outright instead of being flattened, as
+// they only show up in crafted archives (zip-slip).
+func safeEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+
+	if path.IsAbs(name) || strings.Contains(name, ":") {
+		return "", FileError{Issue: "unsafe path in archive"}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", FileError{Issue: "unsafe path in archive"}
+		}
+	}
+
+	base := path.Base(name)
+	if base == "." || base == "/" || base == "" {
+		return "", FileError{Issue: "empty file name"}
+	}
+
+	return base, nil
+}
+
+// isArchiveMetadata reports whether the entry is metadata added by archiving tools,
+// like macOS resource forks, which is skipped rather than reported as a failure.
+func isArchiveMetadata(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azdanov/imago/models"
+)
+
+func TestGalleryServiceImportZipKeepsExistingImages(t *testing.T) {
+	ctx := context.Background()
+	galleries := models.NewGalleryService(models.NewMemoryGalleryStore(), nil)
+	galleries.ImageDir = t.TempDir()
+
+	gallery, err := galleries.Create(ctx, "Holidays", 1)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	existing := pngImage(t, 1)
+	if err = galleries.CreateImage(ctx, gallery.ID, "beach.png", bytes.NewReader(existing)); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"beach.png", "trip/Beach.png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(pngImage(t, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := galleries.ImportZip(ctx, gallery.ID, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("ImportZip() error = %v", err)
+	}
+	for i, want := range []string{"beach (1).png", "Beach (2).png"} {
+		if results[i].Err != nil || results[i].Filename != want {
+			t.Errorf("ImportZip() result %d = %+v, want it stored as %q", i, results[i], want)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(galleries.ImageDir, "gallery_1", "beach.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, existing) {
+		t.Errorf("ImportZip() replaced the existing beach.png")
+	}
+}
@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azdanov/imago/models"
+)
+
+// pngImage returns a blank square PNG image of size pixels.
+func pngImage(t *testing.T, size int) []byte {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatal(err)
+	}
+	return img.Bytes()
+}
+
+func TestGalleryServiceVerifyImages(t *testing.T) {
+	ctx := context.Background()
+	galleries := models.NewGalleryService(models.NewMemoryGalleryStore(), nil)
+	galleries.ImageDir = t.TempDir()
+
+	gallery, err := galleries.Create(ctx, "Holidays", 1)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	img := pngImage(t, 1)
+	if err = galleries.CreateImage(ctx, gallery.ID, "beach.png", bytes.NewReader(img)); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+
+	if problems, err := galleries.VerifyImages(ctx); err != nil || len(problems) != 0 {
+		t.Fatalf("VerifyImages() = %v, %v, want no problems", problems, err)
+	}
+
+	galleryDir := filepath.Join(galleries.ImageDir, "gallery_1")
+	files := map[string]string{
+		filepath.Join(galleryDir, "notes.png"):                   "not an image",
+		filepath.Join(galleryDir, "beach.txt"):                   string(img),
+		filepath.Join(galleries.ImageDir, "gallery_99", "a.png"): string(img),
+		filepath.Join(galleries.ImageDir, "stray.png"):           string(img),
+	}
+	for path, contents := range files {
+		if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := galleries.VerifyImages(ctx)
+	if err != nil {
+		t.Fatalf("VerifyImages() error = %v", err)
+	}
+	want := map[string]bool{
+		filepath.Join(galleryDir, "notes.png"):          true,
+		filepath.Join(galleryDir, "beach.txt"):          true,
+		filepath.Join(galleries.ImageDir, "gallery_99"): true,
+		filepath.Join(galleries.ImageDir, "stray.png"):  true,
+	}
+	for _, problem := range problems {
+		if !want[problem.Path] {
+			t.Errorf("VerifyImages() reported %s: %s", problem.Path, problem.Issue)
+		}
+		delete(want, problem.Path)
+	}
+	for path := range want {
+		t.Errorf("VerifyImages() did not report %s", path)
+	}
+}
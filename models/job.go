@@ -0,0 +1,365 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultJobMaxAttempts is how often a job is tried before it is dead-lettered.
+	DefaultJobMaxAttempts = 5
+	// DefaultFinishedJobMaxAge is how long completed jobs are kept for inspection.
+	DefaultFinishedJobMaxAge = 7 * 24 * time.Hour
+	// DefaultJobListLimit is the number of jobs returned by listing methods when no limit is given.
+	DefaultJobListLimit = 100
+
+	jobBaseBackoff = 30 * time.Second
+	jobMaxBackoff  = 1 * time.Hour
+)
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	// JobDead marks a job that failed on every attempt. It stays in the table until
+	// an admin retries it.
+	JobDead JobStatus = "dead"
+)
+
+// Job kinds handled by the worker pool.
+const (
+	JobBuildDataExport      = "export.build"
+	JobPurgeDeletedAccounts = "maintenance.purge_accounts"
+	JobDeleteExpiredExports = "maintenance.delete_expired_exports"
+	JobDeleteExpiredUploads = "maintenance.delete_expired_uploads"
+	JobDeleteFinishedJobs   = "maintenance.delete_finished_jobs"
+	JobDeleteSentEmails     = "maintenance.delete_sent_emails"
+	JobDeleteOldAuditEvents = "maintenance.delete_old_audit_events"
+)
+
+// BuildDataExportJob is the payload of a JobBuildDataExport job.
+type BuildDataExportJob struct {
+	ExportID int    `json:"export_id"`
+	Email    string `json:"email"`
+	Locale   string `json:"locale"`
+}
+
+type Job struct {
+	ID   int64
+	Kind string
+	// Payload is the JSON encoded job argument.
+	Payload     []byte
+	Status      JobStatus
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// JobService is a durable job queue stored in Postgres. Workers claim jobs with
+// FOR UPDATE SKIP LOCKED, so any number of them can poll the same table.
+type JobService struct {
+	DB *sql.DB
+	// MaxAttempts is used for new jobs. Defaults to DefaultJobMaxAttempts.
+	MaxAttempts int
+}
+
+func NewJobService(db *sql.DB, maxAttempts int) *JobService {
+	return &JobService{
+		DB:          db,
+		MaxAttempts: maxAttempts,
+	}
+}
+
+// Enqueue adds a job that runs as soon as a worker is free.
+func (s *JobService) Enqueue(ctx context.Context, kind string, payload any) (*Job, error) {
+	return s.EnqueueAt(ctx, kind, payload, time.Now())
+}
+
+// EnqueueAt adds a job that does not run before runAt.
+func (s *JobService) EnqueueAt(ctx context.Context, kind string, payload any, runAt time.Time) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("enqueue job: %w", err)
+	}
+
+	job := Job{
+		Kind:        kind,
+		Payload:     data,
+		Status:      JobQueued,
+		MaxAttempts: s.maxAttempts(),
+		RunAt:       runAt,
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO jobs (kind, payload, status, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at;`, job.Kind, job.Payload, job.Status, job.MaxAttempts, job.RunAt,
+	).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("enqueue job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// Schedule adds a payload-less job of the given kind at runAt, unless one is already
+// queued or running. It is safe to call from several processes at once.
+func (s *JobService) Schedule(ctx context.Context, kind string, runAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO jobs (kind, status, max_attempts, unique_key, run_at)
+		VALUES ($1, $2, $3, $1, $4)
+		ON CONFLICT (unique_key) WHERE status IN ('queued', 'running') DO NOTHING;`,
+		kind, JobQueued, s.maxAttempts(), runAt)
+	if err != nil {
+		return fmt.Errorf("schedule job: %w", err)
+	}
+
+	return nil
+}
+
+// Claim locks the next due job and marks it running. It returns ErrNotFound when
+// there is nothing to do.
+func (s *JobService) Claim(ctx context.Context) (*Job, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	var job Job
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, kind, payload, attempts, max_attempts, last_error, run_at, created_at
+		FROM jobs
+		WHERE status = $1 AND run_at <= NOW()
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED;`, JobQueued,
+	).Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts, &job.LastError,
+		&job.RunAt, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+
+	job.Status = JobRunning
+	job.Attempts++
+	err = tx.QueryRowContext(ctx, `
+		UPDATE jobs
+		SET status = $1, attempts = $2, locked_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+		RETURNING updated_at;`, job.Status, job.Attempts, job.ID,
+	).Scan(&job.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (s *JobService) Complete(ctx context.Context, job *Job) error {
+	job.Status = JobDone
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = $1, locked_at = NULL, updated_at = NOW()
+		WHERE id = $2;`, job.Status, job.ID)
+	if err != nil {
+		return fmt.Errorf("complete job: %w", err)
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt. The job is retried with exponential backoff until it
+// runs out of attempts, then it is dead-lettered.
+func (s *JobService) Fail(ctx context.Context, job *Job, cause error) error {
+	job.LastError = cause.Error()
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = JobDead
+	} else {
+		job.Status = JobQueued
+		job.RunAt = time.Now().Add(jobBackoff(job.Attempts))
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = $1, last_error = $2, run_at = $3, locked_at = NULL, updated_at = NOW()
+		WHERE id = $4;`, job.Status, job.LastError, job.RunAt, job.ID)
+	if err != nil {
+		return fmt.Errorf("fail job: %w", err)
+	}
+
+	return nil
+}
+
+// Retry puts a dead job back in the queue with a fresh set of attempts.
+func (s *JobService) Retry(ctx context.Context, id int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = $1, attempts = 0, run_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3;`, JobQueued, id, JobDead)
+	if err != nil {
+		return fmt.Errorf("retry job: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("retry job: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ReleaseStale requeues running jobs that have been locked for longer than timeout,
+// which happens when a worker process dies mid-job.
+func (s *JobService) ReleaseStale(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = $1, locked_at = NULL, updated_at = NOW()
+		WHERE status = $2 AND locked_at < $3;`, JobQueued, JobRunning, time.Now().Add(-timeout))
+	if err != nil {
+		return fmt.Errorf("release stale jobs: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFinished removes completed jobs older than maxAge. Dead jobs are kept.
+func (s *JobService) DeleteFinished(ctx context.Context, maxAge time.Duration) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM jobs WHERE status = $1 AND updated_at < $2;`,
+		JobDone, time.Now().Add(-maxAge))
+	if err != nil {
+		return fmt.Errorf("delete finished jobs: %w", err)
+	}
+
+	return nil
+}
+
+// ByStatus returns the jobs with one of the given statuses, the next to run first.
+func (s *JobService) ByStatus(ctx context.Context, limit int, statuses ...JobStatus) ([]Job, error) {
+	if limit <= 0 {
+		limit = DefaultJobListLimit
+	}
+
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		names = append(names, string(status))
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at
+		FROM jobs
+		WHERE status = ANY($1)
+		ORDER BY run_at, id
+		LIMIT $2;`, names, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query jobs by status: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var job Job
+		err = rows.Scan(&job.ID, &job.Kind, &job.Payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+			&job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan job row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate job rows: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// Counts returns the number of jobs per status.
+func (s *JobService) Counts(ctx context.Context) (map[JobStatus]int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status;`)
+	if err != nil {
+		return nil, fmt.Errorf("count jobs: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[JobStatus]int{}
+	for rows.Next() {
+		var status JobStatus
+		var count int
+		if err = rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("scan job count row: %w", err)
+		}
+		counts[status] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate job count rows: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (s *JobService) maxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return DefaultJobMaxAttempts
+	}
+	return s.MaxAttempts
+}
+
+// jobBackoff doubles the delay with every attempt, starting at jobBaseBackoff.
+func jobBackoff(attempt int) time.Duration {
+	backoff := jobBaseBackoff
+	for range attempt - 1 {
+		backoff *= 2
+		if backoff >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/azdanov/imago/logging"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultJobPollInterval is how often an idle worker checks for new jobs.
+	DefaultJobPollInterval = 2 * time.Second
+	// DefaultJobTimeout is how long a job may run before its context is cancelled.
+	// Jobs locked for longer than twice this are considered abandoned and requeued.
+	DefaultJobTimeout = 15 * time.Minute
+)
+
+// JobHandler runs a single job. Returning an error schedules a retry.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// HandleJob adapts a function taking a typed payload to a JobHandler. A payload that
+// cannot be decoded fails the job.
+func HandleJob[T any](fn func(ctx context.Context, payload T) error) JobHandler {
+	return func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("decode %v payload: %w", job.Kind, err)
+		}
+		return fn(ctx, payload)
+	}
+}
+
+type recurringJob struct {
+	kind     string
+	interval time.Duration
+}
+
+// JobWorker runs a pool of goroutines that claim and execute jobs from a JobService.
+type JobWorker struct {
+	JobService *JobService
+	// Concurrency is the number of jobs run in parallel. Defaults to 1.
+	Concurrency int
+	// PollInterval defaults to DefaultJobPollInterval.
+	PollInterval time.Duration
+	// Timeout defaults to DefaultJobTimeout.
+	Timeout time.Duration
+
+	handlers  map[string]JobHandler
+	recurring []recurringJob
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewJobWorker(js *JobService, concurrency int) *JobWorker {
+	return &JobWorker{
+		JobService:  js,
+		Concurrency: concurrency,
+		handlers:    map[string]JobHandler{},
+	}
+}
+
+// Register sets the handler for a job kind. It must be called before Start.
+func (w *JobWorker) Register(kind string, handler JobHandler) {
+	w.handlers[kind] = handler
+}
+
+// Every registers a handler for a job that runs repeatedly, roughly once per interval.
+// It must be called before Start.
+func (w *JobWorker) Every(kind string, interval time.Duration, handler JobHandler) {
+	w.Register(kind, handler)
+	w.recurring = append(w.recurring, recurringJob{kind: kind, interval: interval})
+}
+
+// Start launches the workers. They run until Shutdown is called.
+func (w *JobWorker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.schedule(ctx)
+	}()
+
+	for range max(w.Concurrency, 1) {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.work(ctx)
+		}()
+	}
+}
+
+// Shutdown stops claiming new jobs and waits for running jobs to finish. If ctx ends
+// first, Shutdown returns its error and the remaining jobs are released as stale by
+// the next worker that starts.
+func (w *JobWorker) Shutdown(ctx context.Context) error {
+	if w.cancel == nil {
+		return nil
+	}
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown job worker: %w", ctx.Err())
+	}
+}
+
+func (w *JobWorker) work(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going back to sleep.
+		for ctx.Err() == nil {
+			job, err := w.JobService.Claim(ctx)
+			if err != nil {
+				if !errors.Is(err, ErrNotFound) {
+					logging.FromContext(ctx).Error("claim job", "error", err)
+				}
+				break
+			}
+			w.run(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run executes a claimed job. Running jobs are not tied to the worker context, so a
+// shutdown lets them finish instead of interrupting them halfway.
+func (w *JobWorker) run(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout())
+	defer cancel()
+
+	logger := logging.FromContext(ctx).With("job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+	ctx = logging.WithLogger(ctx, logger)
+
+	ctx, span := startSpan(ctx, "job "+job.Kind,
+		attribute.Int64("job.id", job.ID),
+		attribute.Int("job.attempt", job.Attempts),
+	)
+	err := w.handle(ctx, job)
+	endSpan(span, err)
+
+	// The outcome is recorded even when the job ran out of time.
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		logger.Error("job failed", "error", err)
+		if err = w.JobService.Fail(ctx, job, err); err != nil {
+			logger.Error("fail job", "error", err)
+		}
+		return
+	}
+
+	if err = w.JobService.Complete(ctx, job); err != nil {
+		logger.Error("complete job", "error", err)
+	}
+}
+
+func (w *JobWorker) handle(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	return handler(ctx, job)
+}
+
+// schedule keeps one pending job queued for every recurring kind and requeues jobs
+// abandoned by crashed workers.
+func (w *JobWorker) schedule(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := w.JobService.ReleaseStale(ctx, 2*w.timeout()); err != nil {
+			logging.FromContext(ctx).Error("release stale jobs", "error", err)
+		}
+		for _, job := range w.recurring {
+			if err := w.JobService.Schedule(ctx, job.kind, time.Now().Add(job.interval)); err != nil {
+				logging.FromContext(ctx).Error("schedule job", "job_kind", job.kind, "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *JobWorker) pollInterval() time.Duration {
+	if w.PollInterval <= 0 {
+		return DefaultJobPollInterval
+	}
+	return w.PollInterval
+}
+
+func (w *JobWorker) timeout() time.Duration {
+	if w.Timeout <= 0 {
+		return DefaultJobTimeout
+	}
+	return w.Timeout
+}
@@ -10,6 +10,7 @@ type Notification struct {
 const (
 	NotificationSuccess = "success"
 	NotificationError   = "error"
+	NotificationInfo    = "info"
 )
 
 // SortNotifications sorts notifications by priority: success > error > others.
@@ -1,10 +1,9 @@
 package models
 
 import (
+	"context"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"time"
 
@@ -18,37 +17,60 @@ const (
 type PasswordReset struct {
 	ID     int
 	UserID int
+	// Locale is the language of the user the reset is for.
+	Locale string
 	// Token is only created initially and never stored in the database.
 	Token     string
 	TokenHash string
 	CreatedAt time.Time
 }
 
+// PasswordResetStore persists reset tokens by their hash. A user has at most one
+// reset token.
+type PasswordResetStore interface {
+	// Save stores the reset, replacing an earlier one of the same user, and sets its
+	// ID. The message telling the user about it is queued atomically with the reset,
+	// either both are saved or neither is.
+	Save(ctx context.Context, reset *PasswordReset, message OutboxMessage) error
+	// ByTokenHash returns the reset with the token hash, or ErrNotFound.
+	ByTokenHash(ctx context.Context, tokenHash string) (*PasswordReset, error)
+	Delete(ctx context.Context, id int) error
+}
+
 type PasswordResetService struct {
-	DB *sql.DB
+	Store PasswordResetStore
+	// Users looks up the user a reset is requested for.
+	Users UserStore
 	// SessionTokenBytes is the number of bytes used to generate a session token.
 	// If the value is less than MinSessionTokenBytes, MinSessionTokenBytes will be used.
 	BytesPerToken int
 	TokenLifetime time.Duration
 }
 
-func NewPasswordResetService(db *sql.DB, bytesPerToken int, tokenLifetime time.Duration) *PasswordResetService {
+func NewPasswordResetService(
+	store PasswordResetStore,
+	users UserStore,
+	bytesPerToken int,
+	tokenLifetime time.Duration,
+) *PasswordResetService {
 	return &PasswordResetService{
-		DB:            db,
+		Store:         store,
+		Users:         users,
 		BytesPerToken: bytesPerToken,
 		TokenLifetime: tokenLifetime,
 	}
 }
 
-func (s *PasswordResetService) Generate(email string) (*PasswordReset, error) {
-	var userID int
-
-	query := s.DB.QueryRow(`SELECT id FROM users WHERE email = $1;`, email)
-	err := query.Scan(&userID)
+// Generate creates a reset token for the user with the given email. message builds
+// the email with the token, which is queued along with the reset, so it is only sent
+// if the token is saved, and vice versa.
+func (s *PasswordResetService) Generate(
+	ctx context.Context,
+	email string,
+	message func(reset *PasswordReset) (OutboxMessage, error),
+) (*PasswordReset, error) {
+	user, err := s.Users.ByEmail(ctx, email)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("generate: %w", err)
 	}
 
@@ -63,56 +85,46 @@ func (s *PasswordResetService) Generate(email string) (*PasswordReset, error) {
 	}
 
 	resetToken := PasswordReset{
-		UserID:    userID,
+		UserID:    user.ID,
+		Locale:    user.Locale,
 		Token:     token,
 		TokenHash: s.hash(token),
 		CreatedAt: time.Now(),
 	}
 
-	query = s.DB.QueryRow(`
-		INSERT INTO reset_tokens (user_id, token_hash, created_at)
-		VALUES ($1, $2, $3) ON CONFLICT (user_id)
-		DO UPDATE SET token_hash = $2, created_at = $3
-		RETURNING id;`, resetToken.UserID, resetToken.TokenHash, resetToken.CreatedAt)
-
-	err = query.Scan(&resetToken.ID)
+	notification, err := message(&resetToken)
 	if err != nil {
 		return nil, fmt.Errorf("generate: %w", err)
 	}
+	if err = s.Store.Save(ctx, &resetToken, notification); err != nil {
+		return nil, fmt.Errorf("generate: %w", err)
+	}
 
 	return &resetToken, nil
 }
 
-func (s *PasswordResetService) GetUserByToken(token string) (*User, error) {
-	tokenHash := s.hash(token)
-
-	var user User
-	var passwordReset PasswordReset
-
-	query := s.DB.QueryRow(`
-			SELECT rt.id, rt.created_at, u.id, u.email, u.password_hash
-			FROM reset_tokens rt
-			JOIN users u ON u.id = rt.user_id
-			WHERE rt.token_hash = $1;`, tokenHash)
-
-	err := query.Scan(&passwordReset.ID, &passwordReset.CreatedAt, &user.ID, &user.Email, &user.PasswordHash)
-	if errors.Is(err, sql.ErrNoRows) {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
+// GetUserByToken returns the user the token was generated for and deletes the token,
+// so it can only be used once.
+func (s *PasswordResetService) GetUserByToken(ctx context.Context, token string) (*User, error) {
+	reset, err := s.Store.ByTokenHash(ctx, s.hash(token))
+	if err != nil {
 		return nil, fmt.Errorf("get user: %w", err)
 	}
 
-	if time.Now().After(passwordReset.CreatedAt.Add(s.TokenLifetime)) {
+	if time.Now().After(reset.CreatedAt.Add(s.TokenLifetime)) {
 		return nil, fmt.Errorf("token expired: %v", token)
 	}
 
-	err = s.delete(passwordReset.ID)
+	user, err := s.Users.ByID(ctx, reset.UserID)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get user: %w", err)
 	}
 
-	return &user, nil
+	if err = s.Store.Delete(ctx, reset.ID); err != nil {
+		return nil, fmt.Errorf("delete: %w", err)
+	}
+
+	return user, nil
 }
 
 func (s *PasswordResetService) hash(token string) string {
@@ -120,12 +132,3 @@ func (s *PasswordResetService) hash(token string) string {
 
 	return base64.URLEncoding.EncodeToString(tokenHash[:])
 }
-
-func (s *PasswordResetService) delete(id int) error {
-	_, err := s.DB.Exec(`DELETE FROM reset_tokens WHERE id = $1;`, id)
-	if err != nil {
-		return fmt.Errorf("delete: %w", err)
-	}
-
-	return nil
-}
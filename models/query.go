@@ -0,0 +1,16 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultQueryTimeout bounds the database work of a single service call, on top of
+// the cancellation of the caller's context, so a stuck statement cannot hold on to a
+// pooled connection.
+const DefaultQueryTimeout = 5 * time.Second
+
+// queryContext returns a copy of ctx that expires after DefaultQueryTimeout.
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, DefaultQueryTimeout)
+}
@@ -1,12 +1,12 @@
 package models
 
 import (
+	"context"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base64"
-	"errors"
 	"fmt"
 
+	"github.com/azdanov/imago/metrics"
 	"github.com/azdanov/imago/rand"
 )
 
@@ -21,21 +21,37 @@ type Session struct {
 	TokenHash string `json:"-"`
 }
 
+// SessionStore persists sessions by the hash of their token. A user has at most one
+// session.
+type SessionStore interface {
+	// Save stores the session, replacing an earlier one of the same user, and sets
+	// its ID.
+	Save(ctx context.Context, session *Session) error
+	Count(ctx context.Context) (int, error)
+	// User returns the enabled user owning the session with the token hash, or
+	// ErrNotFound.
+	User(ctx context.Context, tokenHash string) (*User, error)
+	Delete(ctx context.Context, tokenHash string) error
+	DeleteByUserID(ctx context.Context, userID int) error
+	// DeleteAll removes every session and returns how many there were.
+	DeleteAll(ctx context.Context) (int, error)
+}
+
 type SessionService struct {
-	DB *sql.DB
+	Store SessionStore
 	// SessionTokenBytes is the number of bytes used to generate a session token.
 	// If the value is less than MinSessionTokenBytes, MinSessionTokenBytes will be used.
 	SessionTokenBytes int
 }
 
-func NewSessionService(db *sql.DB, minSessionTokenBytes int) *SessionService {
+func NewSessionService(store SessionStore, minSessionTokenBytes int) *SessionService {
 	return &SessionService{
-		DB:                db,
+		Store:             store,
 		SessionTokenBytes: minSessionTokenBytes,
 	}
 }
 
-func (s *SessionService) Create(userID int) (*Session, error) {
+func (s *SessionService) Create(ctx context.Context, userID int) (*Session, error) {
 	bytesPerToken := max(s.SessionTokenBytes, MinSessionTokenBytes)
 	token, err := rand.String(bytesPerToken)
 	if err != nil {
@@ -43,58 +59,67 @@ func (s *SessionService) Create(userID int) (*Session, error) {
 	}
 
 	session := &Session{
-		UserID: userID,
-		Token:  token,
+		UserID:    userID,
+		Token:     token,
+		TokenHash: s.hashToken(token),
 	}
 
-	tokenHash := s.hashToken(token)
-
-	err = s.DB.QueryRow(`
-    INSERT INTO sessions (user_id, token_hash)
-    VALUES ($1, $2)
-    ON CONFLICT (user_id) DO UPDATE SET token_hash = $2
-    RETURNING id
-  `, session.UserID, tokenHash).Scan(&session.ID)
-	if err != nil {
-		return nil, err
+	if err = s.Store.Save(ctx, session); err != nil {
+		return nil, fmt.Errorf("create: %w", err)
 	}
 
+	metrics.SessionsCreated.Inc()
+
 	return session, nil
 }
 
-func (s *SessionService) User(token string) (*User, error) {
-	tokenHash := s.hashToken(token)
+// Count returns the number of active sessions.
+func (s *SessionService) Count(ctx context.Context) (int, error) {
+	count, err := s.Store.Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("count sessions: %w", err)
+	}
 
-	user := &User{}
-	err := s.DB.QueryRow(`
-      SELECT u.id, u.email, u.password_hash
-      FROM sessions s
-      INNER JOIN users u ON s.user_id = u.id
-      WHERE s.token_hash = $1
-    `, tokenHash).Scan(&user.ID, &user.Email, &user.PasswordHash)
+	return count, nil
+}
+
+func (s *SessionService) User(ctx context.Context, token string) (*User, error) {
+	user, err := s.Store.User(ctx, s.hashToken(token))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("user: %w", err)
 	}
 
 	return user, nil
 }
 
-func (s *SessionService) Delete(token string) error {
-	tokenHash := s.hashToken(token)
-
-	_, err := s.DB.Exec(`
-    DELETE FROM sessions WHERE token_hash = $1
-  `, tokenHash)
-	if err != nil {
+func (s *SessionService) Delete(ctx context.Context, token string) error {
+	if err := s.Store.Delete(ctx, s.hashToken(token)); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
 
 	return nil
 }
 
+// DeleteByUserID removes every session belonging to the user, signing them out everywhere.
+func (s *SessionService) DeleteByUserID(ctx context.Context, userID int) error {
+	if err := s.Store.DeleteByUserID(ctx, userID); err != nil {
+		return fmt.Errorf("delete by user id: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAll removes every session, signing out all users, and returns how many were
+// removed.
+func (s *SessionService) DeleteAll(ctx context.Context) (int, error) {
+	count, err := s.Store.DeleteAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete all: %w", err)
+	}
+
+	return count, nil
+}
+
 func (s *SessionService) hashToken(token string) string {
 	hash := sha256.Sum256([]byte(token))
 	return base64.URLEncoding.EncodeToString(hash[:])
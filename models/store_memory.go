@@ -0,0 +1,504 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore keeps users in memory, for tests that do not need a database.
+type MemoryUserStore struct {
+	mu     sync.Mutex
+	users  map[int]User
+	lastID int
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users: map[int]User{},
+	}
+}
+
+func (s *MemoryUserStore) Create(_ context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return ErrEmailAlreadyExists
+		}
+	}
+
+	s.lastID++
+	user.ID = s.lastID
+	user.CreatedAt = time.Now()
+	s.users[user.ID] = *user
+
+	return nil
+}
+
+func (s *MemoryUserStore) ByID(_ context.Context, id int) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &u, nil
+}
+
+func (s *MemoryUserStore) ByEmail(_ context.Context, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *MemoryUserStore) Search(_ context.Context, term string) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []User
+	for _, u := range s.users {
+		if strings.Contains(strings.ToLower(u.Email), strings.ToLower(term)) {
+			users = append(users, u)
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int { return a.ID - b.ID })
+
+	return users, nil
+}
+
+func (s *MemoryUserStore) UpdatePassword(_ context.Context, id int, passwordHash string) error {
+	s.update(id, func(u *User) { u.PasswordHash = passwordHash })
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateLocale(_ context.Context, id int, locale string) error {
+	s.update(id, func(u *User) { u.Locale = locale })
+	return nil
+}
+
+func (s *MemoryUserStore) Disable(_ context.Context, id int) error {
+	s.update(id, func(u *User) {
+		if u.DisabledAt == nil {
+			now := time.Now()
+			u.DisabledAt = &now
+		}
+	})
+	return nil
+}
+
+func (s *MemoryUserStore) Enable(_ context.Context, id int) error {
+	s.update(id, func(u *User) { u.DisabledAt = nil })
+	return nil
+}
+
+// update applies fn to the user with the ID. Like an UPDATE matching no rows, a
+// missing user is ignored.
+func (s *MemoryUserStore) update(id int, fn func(u *User)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return
+	}
+	fn(&u)
+	s.users[id] = u
+}
+
+// MemorySessionStore keeps sessions in memory, for tests that do not need a database.
+type MemorySessionStore struct {
+	// Users resolves the user of a session.
+	Users *MemoryUserStore
+
+	mu       sync.Mutex
+	sessions map[int]Session
+	lastID   int
+}
+
+func NewMemorySessionStore(users *MemoryUserStore) *MemorySessionStore {
+	return &MemorySessionStore{
+		Users:    users,
+		sessions: map[int]Session{},
+	}
+}
+
+func (s *MemorySessionStore) Save(_ context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, existing := range s.sessions {
+		if existing.UserID == session.UserID {
+			session.ID = id
+			s.sessions[id] = *session
+			return nil
+		}
+	}
+
+	s.lastID++
+	session.ID = s.lastID
+	s.sessions[session.ID] = *session
+
+	return nil
+}
+
+func (s *MemorySessionStore) Count(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions), nil
+}
+
+func (s *MemorySessionStore) User(ctx context.Context, tokenHash string) (*User, error) {
+	s.mu.Lock()
+	userID := 0
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			userID = session.UserID
+		}
+	}
+	s.mu.Unlock()
+
+	if userID == 0 {
+		return nil, ErrNotFound
+	}
+
+	user, err := s.Users.ByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDisabled() {
+		return nil, ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (s *MemorySessionStore) Delete(_ context.Context, tokenHash string) error {
+	s.deleteFunc(func(session Session) bool { return session.TokenHash == tokenHash })
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteByUserID(_ context.Context, userID int) error {
+	s.deleteFunc(func(session Session) bool { return session.UserID == userID })
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteAll(_ context.Context) (int, error) {
+	return s.deleteFunc(func(Session) bool { return true }), nil
+}
+
+// deleteFunc removes the sessions for which del returns true and returns how many.
+func (s *MemorySessionStore) deleteFunc(del func(session Session) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, session := range s.sessions {
+		if del(session) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// MemoryPasswordResetStore keeps reset tokens in memory, for tests that do not need a
+// database. Their emails are queued in Outbox.
+type MemoryPasswordResetStore struct {
+	Outbox *MemoryEmailOutbox
+
+	mu     sync.Mutex
+	resets map[int]PasswordReset
+	lastID int
+}
+
+func NewMemoryPasswordResetStore(outbox *MemoryEmailOutbox) *MemoryPasswordResetStore {
+	return &MemoryPasswordResetStore{
+		Outbox: outbox,
+		resets: map[int]PasswordReset{},
+	}
+}
+
+// Save holds the lock of the outbox while storing the reset, so nobody sees one
+// without the other.
+func (s *MemoryPasswordResetStore) Save(_ context.Context, reset *PasswordReset, message OutboxMessage) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Outbox.mu.Lock()
+	defer s.Outbox.mu.Unlock()
+	s.Outbox.queue(message)
+
+	for id, existing := range s.resets {
+		if existing.UserID == reset.UserID {
+			delete(s.resets, id)
+		}
+	}
+
+	s.lastID++
+	reset.ID = s.lastID
+	saved := *reset
+	saved.Token = ""
+	s.resets[reset.ID] = saved
+
+	return nil
+}
+
+func (s *MemoryPasswordResetStore) ByTokenHash(_ context.Context, tokenHash string) (*PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reset := range s.resets {
+		if reset.TokenHash == tokenHash {
+			return &reset, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *MemoryPasswordResetStore) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.resets, id)
+	return nil
+}
+
+// MemoryGalleryStore keeps galleries in memory, for tests that do not need a database.
+type MemoryGalleryStore struct {
+	mu        sync.Mutex
+	galleries map[int]Gallery
+	lastID    int
+}
+
+func NewMemoryGalleryStore() *MemoryGalleryStore {
+	return &MemoryGalleryStore{
+		galleries: map[int]Gallery{},
+	}
+}
+
+func (s *MemoryGalleryStore) Create(_ context.Context, gallery *Gallery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	gallery.ID = s.lastID
+	s.galleries[gallery.ID] = *gallery
+
+	return nil
+}
+
+func (s *MemoryGalleryStore) ByID(_ context.Context, id int) (*Gallery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gallery, ok := s.galleries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &gallery, nil
+}
+
+func (s *MemoryGalleryStore) ByUserID(_ context.Context, userID int) ([]Gallery, error) {
+	return s.filter(func(gallery Gallery) bool { return gallery.UserID == userID }), nil
+}
+
+func (s *MemoryGalleryStore) All(_ context.Context) ([]Gallery, error) {
+	return s.filter(func(Gallery) bool { return true }), nil
+}
+
+func (s *MemoryGalleryStore) Update(_ context.Context, gallery *Gallery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.galleries[gallery.ID]
+	if !ok {
+		return nil
+	}
+	existing.Title = gallery.Title
+	s.galleries[gallery.ID] = existing
+
+	return nil
+}
+
+func (s *MemoryGalleryStore) Delete(_ context.Context, id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gallery, ok := s.galleries[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	delete(s.galleries, id)
+
+	return gallery.UserID, nil
+}
+
+// filter returns the galleries matching keep, ordered by ID.
+func (s *MemoryGalleryStore) filter(keep func(gallery Gallery) bool) []Gallery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var galleries []Gallery
+	for _, gallery := range s.galleries {
+		if keep(gallery) {
+			galleries = append(galleries, gallery)
+		}
+	}
+	slices.SortFunc(galleries, func(a, b Gallery) int { return a.ID - b.ID })
+
+	return galleries
+}
+
+// MemoryEmailOutbox keeps queued emails in memory, for tests that do not need a
+// database.
+type MemoryEmailOutbox struct {
+	mu     sync.Mutex
+	emails []memoryOutboxEmail
+	lastID int64
+}
+
+type memoryOutboxEmail struct {
+	OutboxEmail
+	status       EmailStatus
+	nextAttempt  time.Time
+	claimedUntil time.Time
+	sentAt       time.Time
+	lastError    string
+}
+
+func NewMemoryEmailOutbox() *MemoryEmailOutbox {
+	return &MemoryEmailOutbox{}
+}
+
+func (s *MemoryEmailOutbox) Queue(_ context.Context, message OutboxMessage) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue(message)
+	return nil
+}
+
+// queue must be called with s.mu held.
+func (s *MemoryEmailOutbox) queue(message OutboxMessage) {
+	s.lastID++
+	s.emails = append(s.emails, memoryOutboxEmail{
+		OutboxEmail: OutboxEmail{Email: message.Email, ID: s.lastID, MaxAttempts: message.MaxAttempts},
+		status:      EmailPending,
+		nextAttempt: time.Now(),
+	})
+}
+
+func (s *MemoryEmailOutbox) Claim(_ context.Context, limit int, lease time.Duration) ([]OutboxEmail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var claimed []OutboxEmail
+	for i := range s.emails {
+		email := &s.emails[i]
+		due := email.status == EmailPending && !email.nextAttempt.After(now)
+		expired := email.status == EmailSending && !email.claimedUntil.After(now)
+		if len(claimed) == limit || !due && !expired {
+			continue
+		}
+		email.status = EmailSending
+		email.claimedUntil = now.Add(lease)
+		claimed = append(claimed, email.OutboxEmail)
+	}
+
+	return claimed, nil
+}
+
+func (s *MemoryEmailOutbox) MarkSent(_ context.Context, id int64, attempts int) error {
+	s.mark(id, func(email *memoryOutboxEmail) {
+		email.status = EmailSent
+		email.Attempts = attempts
+		email.sentAt = time.Now()
+	})
+	return nil
+}
+
+func (s *MemoryEmailOutbox) MarkRetry(
+	_ context.Context,
+	id int64,
+	attempts int,
+	lastError string,
+	next time.Time,
+) error {
+	s.mark(id, func(email *memoryOutboxEmail) {
+		email.status = EmailPending
+		email.Attempts = attempts
+		email.lastError = lastError
+		email.nextAttempt = next
+	})
+	return nil
+}
+
+func (s *MemoryEmailOutbox) MarkFailed(_ context.Context, id int64, attempts int, lastError string) error {
+	s.mark(id, func(email *memoryOutboxEmail) {
+		email.status = EmailFailed
+		email.Attempts = attempts
+		email.lastError = lastError
+	})
+	return nil
+}
+
+// mark applies update to the email with id if it is still claimed.
+func (s *MemoryEmailOutbox) mark(id int64, update func(email *memoryOutboxEmail)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.emails {
+		if s.emails[i].ID == id && s.emails[i].status == EmailSending {
+			update(&s.emails[i])
+		}
+	}
+}
+
+func (s *MemoryEmailOutbox) Count(_ context.Context, status EmailStatus) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, email := range s.emails {
+		if email.status == status {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *MemoryEmailOutbox) DeleteSent(_ context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emails = slices.DeleteFunc(s.emails, func(email memoryOutboxEmail) bool {
+		return email.status == EmailSent && email.sentAt.Before(before)
+	})
+	return nil
+}
@@ -0,0 +1,520 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PostgresUserStore keeps users in the users table.
+type PostgresUserStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{
+		DB: db,
+	}
+}
+
+func (s *PostgresUserStore) Create(ctx context.Context, user *User) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO users (email, password_hash, role, locale)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	err := s.DB.QueryRowContext(ctx, query, user.Email, user.PasswordHash, user.Role, user.Locale).
+		Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrEmailAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresUserStore) ByID(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, email, password_hash, role, locale, disabled_at, deletion_requested_at, created_at
+		FROM users
+		WHERE id = $1`
+	return scanUser(s.DB.QueryRowContext(ctx, query, id))
+}
+
+func (s *PostgresUserStore) ByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, email, password_hash, role, locale, disabled_at, deletion_requested_at, created_at
+		FROM users
+		WHERE email = $1`
+	return scanUser(s.DB.QueryRowContext(ctx, query, email))
+}
+
+func (s *PostgresUserStore) Search(ctx context.Context, term string) ([]User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, email, password_hash, role, locale, disabled_at, deletion_requested_at, created_at
+		FROM users
+		WHERE email ILIKE '%' || $1 || '%' ESCAPE '\'
+		ORDER BY id;`
+	rows, err := s.DB.QueryContext(ctx, query, escapeLike(term))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsers(rows)
+}
+
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, id)
+	return err
+}
+
+func (s *PostgresUserStore) UpdateLocale(ctx context.Context, id int, locale string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET locale = $1 WHERE id = $2`, locale, id)
+	return err
+}
+
+func (s *PostgresUserStore) Disable(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET disabled_at = NOW() WHERE id = $1 AND disabled_at IS NULL`, id)
+	return err
+}
+
+func (s *PostgresUserStore) Enable(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET disabled_at = NULL WHERE id = $1`, id)
+	return err
+}
+
+// PostgresSessionStore keeps sessions in the sessions table.
+type PostgresSessionStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresSessionStore(db *sql.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		DB: db,
+	}
+}
+
+func (s *PostgresSessionStore) Save(ctx context.Context, session *Session) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return s.DB.QueryRowContext(ctx, `
+    INSERT INTO sessions (user_id, token_hash)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET token_hash = $2
+    RETURNING id
+  `, session.UserID, session.TokenHash).Scan(&session.ID)
+}
+
+func (s *PostgresSessionStore) Count(ctx context.Context) (int, error) {
+	var count int
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions;`).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresSessionStore) User(ctx context.Context, tokenHash string) (*User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return scanUser(s.DB.QueryRowContext(ctx, `
+      SELECT u.id, u.email, u.password_hash, u.role, u.locale, u.disabled_at, u.deletion_requested_at, u.created_at
+      FROM sessions s
+      INNER JOIN users u ON s.user_id = u.id
+      WHERE s.token_hash = $1 AND u.disabled_at IS NULL
+    `, tokenHash))
+}
+
+func (s *PostgresSessionStore) Delete(ctx context.Context, tokenHash string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+func (s *PostgresSessionStore) DeleteByUserID(ctx context.Context, userID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
+
+func (s *PostgresSessionStore) DeleteAll(ctx context.Context) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM sessions`)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// PostgresPasswordResetStore keeps reset tokens in the reset_tokens table.
+type PostgresPasswordResetStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresPasswordResetStore(db *sql.DB) *PostgresPasswordResetStore {
+	return &PostgresPasswordResetStore{
+		DB: db,
+	}
+}
+
+// Save queues the message in the email_outbox table in the transaction that stores
+// the reset.
+func (s *PostgresPasswordResetStore) Save(ctx context.Context, reset *PasswordReset, message OutboxMessage) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO reset_tokens (user_id, token_hash, created_at)
+		VALUES ($1, $2, $3) ON CONFLICT (user_id)
+		DO UPDATE SET token_hash = $2, created_at = $3
+		RETURNING id;`, reset.UserID, reset.TokenHash, reset.CreatedAt).Scan(&reset.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = queueEmail(ctx, tx, message); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresPasswordResetStore) ByTokenHash(ctx context.Context, tokenHash string) (*PasswordReset, error) {
+	reset := PasswordReset{TokenHash: tokenHash}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT rt.id, rt.user_id, u.locale, rt.created_at
+		FROM reset_tokens rt
+		JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1;`, tokenHash).Scan(&reset.ID, &reset.UserID, &reset.Locale, &reset.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+func (s *PostgresPasswordResetStore) Delete(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM reset_tokens WHERE id = $1;`, id)
+	return err
+}
+
+// PostgresGalleryStore keeps galleries in the galleries table.
+type PostgresGalleryStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresGalleryStore(db *sql.DB) *PostgresGalleryStore {
+	return &PostgresGalleryStore{
+		DB: db,
+	}
+}
+
+func (s *PostgresGalleryStore) Create(ctx context.Context, gallery *Gallery) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return s.DB.QueryRowContext(ctx,
+		`INSERT INTO galleries (title, user_id, created_at) VALUES ($1, $2, $3) RETURNING id;`,
+		gallery.Title, gallery.UserID, gallery.CreatedAt).Scan(&gallery.ID)
+}
+
+func (s *PostgresGalleryStore) ByID(ctx context.Context, id int) (*Gallery, error) {
+	gallery := Gallery{}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, title, created_at FROM galleries WHERE id = $1;`
+	err := s.DB.QueryRowContext(ctx, query, id).Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &gallery, nil
+}
+
+func (s *PostgresGalleryStore) ByUserID(ctx context.Context, userID int) ([]Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, title, created_at FROM galleries WHERE user_id = $1;`
+	rows, err := s.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGalleries(rows)
+}
+
+func (s *PostgresGalleryStore) All(ctx context.Context) ([]Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, title, created_at FROM galleries ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGalleries(rows)
+}
+
+func (s *PostgresGalleryStore) Update(ctx context.Context, gallery *Gallery) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE galleries SET title = $1 WHERE id = $2;`, gallery.Title, gallery.ID)
+	return err
+}
+
+func (s *PostgresGalleryStore) Delete(ctx context.Context, id int) (int, error) {
+	var userID int
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `DELETE FROM galleries WHERE id = $1 RETURNING user_id;`, id).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// scanUser reads a user selected with every column of the users table, in the order
+// they are declared on User.
+// likeEscaper escapes the wildcards of LIKE patterns using ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes term match itself in a LIKE pattern, so searches find the text
+// as typed like strings.Contains does.
+func escapeLike(term string) string {
+	return likeEscaper.Replace(term)
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.Locale,
+		&u.DisabledAt, &u.DeletionRequestedAt, &u.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func scanUsers(rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.Locale,
+			&u.DisabledAt, &u.DeletionRequestedAt, &u.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan user row: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+func scanGalleries(rows *sql.Rows) ([]Gallery, error) {
+	defer rows.Close()
+
+	var galleries []Gallery
+	for rows.Next() {
+		var gallery Gallery
+		err := rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan gallery row: %w", err)
+		}
+		galleries = append(galleries, gallery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate gallery rows: %w", err)
+	}
+
+	return galleries, nil
+}
+
+// PostgresEmailOutbox keeps queued emails in the email_outbox table.
+type PostgresEmailOutbox struct {
+	DB *sql.DB
+}
+
+func NewPostgresEmailOutbox(db *sql.DB) *PostgresEmailOutbox {
+	return &PostgresEmailOutbox{
+		DB: db,
+	}
+}
+
+func (s *PostgresEmailOutbox) Queue(ctx context.Context, message OutboxMessage) error {
+	return queueEmail(ctx, s.DB, message)
+}
+
+// Claim skips rows locked by concurrent claims, so dispatchers never wait on each other.
+func (s *PostgresEmailOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEmail, error) {
+	return s.claim(ctx, `
+		SELECT id FROM email_outbox
+		WHERE (status = $3 AND next_attempt_at <= $4) OR (status = $1 AND claimed_until <= $4)
+		ORDER BY next_attempt_at, id
+		LIMIT $5
+		FOR UPDATE SKIP LOCKED`, limit, lease)
+}
+
+// claim marks the emails selected by the due query as sending, see Claim for its
+// parameters.
+func (s *PostgresEmailOutbox) claim(
+	ctx context.Context,
+	due string,
+	limit int,
+	lease time.Duration,
+) ([]OutboxEmail, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE email_outbox
+		SET status = $1, claimed_until = $2, updated_at = $4
+		WHERE id IN (`+due+`)
+		RETURNING id, sender, recipient, subject, plaintext, html, attempts, max_attempts;`,
+		EmailSending, now.Add(lease), EmailPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []OutboxEmail
+	for rows.Next() {
+		var email OutboxEmail
+		err = rows.Scan(&email.ID, &email.From, &email.To, &email.Subject, &email.Plaintext, &email.HTML,
+			&email.Attempts, &email.MaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, rows.Err()
+}
+
+func (s *PostgresEmailOutbox) MarkSent(ctx context.Context, id int64, attempts int) error {
+	now := time.Now()
+	return s.mark(ctx, id, `status = $3, attempts = $4, sent_at = $5, updated_at = $5`,
+		EmailSent, attempts, now)
+}
+
+func (s *PostgresEmailOutbox) MarkRetry(
+	ctx context.Context,
+	id int64,
+	attempts int,
+	lastError string,
+	next time.Time,
+) error {
+	return s.mark(ctx, id, `status = $3, attempts = $4, last_error = $5, next_attempt_at = $6, updated_at = $7`,
+		EmailPending, attempts, lastError, next, time.Now())
+}
+
+func (s *PostgresEmailOutbox) MarkFailed(ctx context.Context, id int64, attempts int, lastError string) error {
+	return s.mark(ctx, id, `status = $3, attempts = $4, last_error = $5, updated_at = $6`,
+		EmailFailed, attempts, lastError, time.Now())
+}
+
+// mark applies set, whose parameters start at $3, to a claimed email.
+func (s *PostgresEmailOutbox) mark(ctx context.Context, id int64, set string, args ...any) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE email_outbox SET `+set+` WHERE id = $1 AND status = $2;`,
+		append([]any{id, EmailSending}, args...)...)
+	return err
+}
+
+func (s *PostgresEmailOutbox) Count(ctx context.Context, status EmailStatus) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM email_outbox WHERE status = $1;`, status).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresEmailOutbox) DeleteSent(ctx context.Context, before time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM email_outbox WHERE status = $1 AND sent_at < $2;`, EmailSent, before)
+	return err
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The SQLite stores run the queries of the Postgres stores, SQLite understands their
+// placeholders, RETURNING and ON CONFLICT clauses. Only the queries using Postgres
+// functions are replaced. Open the database with database.NewSQLite, which creates
+// the schema.
+
+// SQLiteUserStore keeps users in the users table of a SQLite database.
+type SQLiteUserStore struct {
+	PostgresUserStore
+}
+
+func NewSQLiteUserStore(db *sql.DB) *SQLiteUserStore {
+	return &SQLiteUserStore{
+		PostgresUserStore: PostgresUserStore{DB: db},
+	}
+}
+
+func (s *SQLiteUserStore) Create(ctx context.Context, user *User) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO users (email, password_hash, role, locale, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	err := s.DB.QueryRowContext(ctx, query, user.Email, user.PasswordHash, user.Role, user.Locale, time.Now()).
+		Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return ErrEmailAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Search matches like the Postgres store as long as emails are ASCII, LIKE in SQLite
+// only ignores the case of ASCII letters.
+func (s *SQLiteUserStore) Search(ctx context.Context, term string) ([]User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, email, password_hash, role, locale, disabled_at, deletion_requested_at, created_at
+		FROM users
+		WHERE email LIKE '%' || $1 || '%' ESCAPE '\'
+		ORDER BY id;`
+	rows, err := s.DB.QueryContext(ctx, query, escapeLike(term))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsers(rows)
+}
+
+func (s *SQLiteUserStore) Disable(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET disabled_at = $1 WHERE id = $2 AND disabled_at IS NULL`,
+		time.Now(), id)
+	return err
+}
+
+// SQLiteSessionStore keeps sessions in the sessions table of a SQLite database.
+type SQLiteSessionStore struct {
+	PostgresSessionStore
+}
+
+func NewSQLiteSessionStore(db *sql.DB) *SQLiteSessionStore {
+	return &SQLiteSessionStore{
+		PostgresSessionStore: PostgresSessionStore{DB: db},
+	}
+}
+
+// SQLitePasswordResetStore keeps reset tokens in the reset_tokens table of a SQLite
+// database.
+type SQLitePasswordResetStore struct {
+	PostgresPasswordResetStore
+}
+
+func NewSQLitePasswordResetStore(db *sql.DB) *SQLitePasswordResetStore {
+	return &SQLitePasswordResetStore{
+		PostgresPasswordResetStore: PostgresPasswordResetStore{DB: db},
+	}
+}
+
+// SQLiteGalleryStore keeps galleries in the galleries table of a SQLite database.
+type SQLiteGalleryStore struct {
+	PostgresGalleryStore
+}
+
+func NewSQLiteGalleryStore(db *sql.DB) *SQLiteGalleryStore {
+	return &SQLiteGalleryStore{
+		PostgresGalleryStore: PostgresGalleryStore{DB: db},
+	}
+}
+
+// SQLiteEmailOutbox keeps queued emails in the email_outbox table of a SQLite database.
+type SQLiteEmailOutbox struct {
+	PostgresEmailOutbox
+}
+
+func NewSQLiteEmailOutbox(db *sql.DB) *SQLiteEmailOutbox {
+	return &SQLiteEmailOutbox{
+		PostgresEmailOutbox: PostgresEmailOutbox{DB: db},
+	}
+}
+
+// Claim needs no row locks, SQLite runs one write at a time.
+func (s *SQLiteEmailOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEmail, error) {
+	return s.claim(ctx, `
+		SELECT id FROM email_outbox
+		WHERE (status = $3 AND next_attempt_at <= $4) OR (status = $1 AND claimed_until <= $4)
+		ORDER BY next_attempt_at, id
+		LIMIT $5`, limit, lease)
+}
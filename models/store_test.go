@@ -0,0 +1,348 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/models"
+)
+
+type stores struct {
+	users     models.UserStore
+	sessions  models.SessionStore
+	resets    models.PasswordResetStore
+	galleries models.GalleryStore
+	outbox    models.EmailOutbox
+}
+
+// backends opens an empty set of stores for every implementation that needs no
+// database server.
+var backends = map[string]func(t *testing.T) stores{
+	"memory": func(*testing.T) stores {
+		users := models.NewMemoryUserStore()
+		outbox := models.NewMemoryEmailOutbox()
+		return stores{
+			users:     users,
+			sessions:  models.NewMemorySessionStore(users),
+			resets:    models.NewMemoryPasswordResetStore(outbox),
+			galleries: models.NewMemoryGalleryStore(),
+			outbox:    outbox,
+		}
+	},
+	"sqlite": func(t *testing.T) stores {
+		db, err := database.NewSQLite(":memory:")
+		if err != nil {
+			t.Fatalf("NewSQLite() error = %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return stores{
+			users:     models.NewSQLiteUserStore(db),
+			sessions:  models.NewSQLiteSessionStore(db),
+			resets:    models.NewSQLitePasswordResetStore(db),
+			galleries: models.NewSQLiteGalleryStore(db),
+			outbox:    models.NewSQLiteEmailOutbox(db),
+		}
+	},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, s stores)) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s models.UserStore, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, PasswordHash: "hash", Role: models.RoleUser, Locale: models.DefaultLocale}
+	if err := s.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create(%q) error = %v", email, err)
+	}
+
+	return user
+}
+
+func TestUserStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+
+		ada := createUser(t, s.users, "ada@example.com")
+		createUser(t, s.users, "grace@example.com")
+		if ada.ID == 0 || ada.CreatedAt.IsZero() {
+			t.Errorf("Create() did not set ID and CreatedAt: %+v", ada)
+		}
+
+		err := s.users.Create(ctx, &models.User{Email: "ada@example.com", PasswordHash: "hash", Role: models.RoleUser})
+		if !errors.Is(err, models.ErrEmailAlreadyExists) {
+			t.Errorf("Create() duplicate error = %v, want ErrEmailAlreadyExists", err)
+		}
+
+		got, err := s.users.ByEmail(ctx, "ada@example.com")
+		if err != nil {
+			t.Fatalf("ByEmail() error = %v", err)
+		}
+		if got.ID != ada.ID || got.Role != models.RoleUser || got.Locale != models.DefaultLocale {
+			t.Errorf("ByEmail() = %+v, want %+v", got, ada)
+		}
+
+		if _, err = s.users.ByID(ctx, 999); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("ByID() missing error = %v, want ErrNotFound", err)
+		}
+
+		users, err := s.users.Search(ctx, "GRACE")
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if len(users) != 1 || users[0].Email != "grace@example.com" {
+			t.Errorf("Search(GRACE) = %+v, want grace@example.com", users)
+		}
+		if users, _ = s.users.Search(ctx, ""); len(users) != 2 || users[0].ID != ada.ID {
+			t.Errorf("Search() = %+v, want both users ordered by ID", users)
+		}
+		// Wildcards are matched literally, "a_a" would match ada otherwise.
+		for _, term := range []string{"a_a", "%", `\`} {
+			if users, _ = s.users.Search(ctx, term); len(users) != 0 {
+				t.Errorf("Search(%q) = %+v, want no users", term, users)
+			}
+		}
+
+		if err = s.users.UpdatePassword(ctx, ada.ID, "new hash"); err != nil {
+			t.Fatalf("UpdatePassword() error = %v", err)
+		}
+		if err = s.users.UpdateLocale(ctx, ada.ID, "de"); err != nil {
+			t.Fatalf("UpdateLocale() error = %v", err)
+		}
+		if err = s.users.Disable(ctx, ada.ID); err != nil {
+			t.Fatalf("Disable() error = %v", err)
+		}
+
+		got, err = s.users.ByID(ctx, ada.ID)
+		if err != nil {
+			t.Fatalf("ByID() error = %v", err)
+		}
+		if got.PasswordHash != "new hash" || got.Locale != "de" || !got.IsDisabled() {
+			t.Errorf("ByID() = %+v, want updated password, locale and disabled", got)
+		}
+
+		if err = s.users.Enable(ctx, ada.ID); err != nil {
+			t.Fatalf("Enable() error = %v", err)
+		}
+		if got, _ = s.users.ByID(ctx, ada.ID); got.IsDisabled() {
+			t.Errorf("ByID() after Enable() is disabled")
+		}
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		ada := createUser(t, s.users, "ada@example.com")
+
+		first := &models.Session{UserID: ada.ID, TokenHash: "first"}
+		if err := s.sessions.Save(ctx, first); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		second := &models.Session{UserID: ada.ID, TokenHash: "second"}
+		if err := s.sessions.Save(ctx, second); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		if count, _ := s.sessions.Count(ctx); count != 1 {
+			t.Errorf("Count() = %d, want 1 session per user", count)
+		}
+		if _, err := s.sessions.User(ctx, "first"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("User() replaced token error = %v, want ErrNotFound", err)
+		}
+
+		user, err := s.sessions.User(ctx, "second")
+		if err != nil {
+			t.Fatalf("User() error = %v", err)
+		}
+		if user.Email != ada.Email {
+			t.Errorf("User() = %q, want %q", user.Email, ada.Email)
+		}
+
+		if err = s.users.Disable(ctx, ada.ID); err != nil {
+			t.Fatalf("Disable() error = %v", err)
+		}
+		if _, err = s.sessions.User(ctx, "second"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("User() of disabled user error = %v, want ErrNotFound", err)
+		}
+
+		if err = s.sessions.DeleteByUserID(ctx, ada.ID); err != nil {
+			t.Fatalf("DeleteByUserID() error = %v", err)
+		}
+		if count, _ := s.sessions.Count(ctx); count != 0 {
+			t.Errorf("Count() after DeleteByUserID() = %d, want 0", count)
+		}
+
+		for _, email := range []string{"grace@example.com", "alan@example.com"} {
+			user := createUser(t, s.users, email)
+			if err = s.sessions.Save(ctx, &models.Session{UserID: user.ID, TokenHash: email}); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+		if deleted, err := s.sessions.DeleteAll(ctx); err != nil || deleted != 2 {
+			t.Errorf("DeleteAll() = %d, %v, want 2 sessions deleted", deleted, err)
+		}
+		if count, _ := s.sessions.Count(ctx); count != 0 {
+			t.Errorf("Count() after DeleteAll() = %d, want 0", count)
+		}
+	})
+}
+
+func TestPasswordResetStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		ada := createUser(t, s.users, "ada@example.com")
+		message := models.OutboxMessage{
+			Email:       models.Email{From: "support@example.com", To: ada.Email, Subject: "Reset", Plaintext: "Reset"},
+			MaxAttempts: 3,
+		}
+
+		failed := &models.PasswordReset{UserID: ada.ID, TokenHash: "failed", CreatedAt: time.Now()}
+		if err := s.resets.Save(ctx, failed, models.OutboxMessage{}); err == nil {
+			t.Fatal("Save() with an email without body error = nil")
+		}
+		if _, err := s.resets.ByTokenHash(ctx, "failed"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("ByTokenHash() after failed Save() error = %v, want ErrNotFound", err)
+		}
+
+		reset := &models.PasswordReset{UserID: ada.ID, TokenHash: "first", CreatedAt: time.Now()}
+		if err := s.resets.Save(ctx, reset, message); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		reset = &models.PasswordReset{UserID: ada.ID, TokenHash: "second", CreatedAt: time.Now()}
+		err := s.resets.Save(ctx, reset, message)
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if count, _ := s.outbox.Count(ctx, models.EmailPending); count != 2 {
+			t.Errorf("Count(pending) = %d, want an email queued by each Save()", count)
+		}
+
+		if _, err = s.resets.ByTokenHash(ctx, "first"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("ByTokenHash() replaced token error = %v, want ErrNotFound", err)
+		}
+		got, err := s.resets.ByTokenHash(ctx, "second")
+		if err != nil {
+			t.Fatalf("ByTokenHash() error = %v", err)
+		}
+		if got.ID != reset.ID || got.UserID != ada.ID {
+			t.Errorf("ByTokenHash() = %+v, want %+v", got, reset)
+		}
+
+		if err = s.resets.Delete(ctx, got.ID); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err = s.resets.ByTokenHash(ctx, "second"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("ByTokenHash() after Delete() error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestGalleryStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		ada := createUser(t, s.users, "ada@example.com")
+		grace := createUser(t, s.users, "grace@example.com")
+
+		holidays := &models.Gallery{UserID: ada.ID, Title: "Holidays", CreatedAt: time.Now()}
+		if err := s.galleries.Create(ctx, holidays); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		cats := &models.Gallery{UserID: grace.ID, Title: "Cats", CreatedAt: time.Now()}
+		if err := s.galleries.Create(ctx, cats); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		holidays.Title = "Summer"
+		if err := s.galleries.Update(ctx, holidays); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		got, err := s.galleries.ByID(ctx, holidays.ID)
+		if err != nil {
+			t.Fatalf("ByID() error = %v", err)
+		}
+		if got.Title != "Summer" || got.UserID != ada.ID {
+			t.Errorf("ByID() = %+v, want %+v", got, holidays)
+		}
+
+		galleries, err := s.galleries.ByUserID(ctx, grace.ID)
+		if err != nil {
+			t.Fatalf("ByUserID() error = %v", err)
+		}
+		if len(galleries) != 1 || galleries[0].ID != cats.ID {
+			t.Errorf("ByUserID() = %+v, want only %q", galleries, cats.Title)
+		}
+
+		userID, err := s.galleries.Delete(ctx, holidays.ID)
+		if err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if userID != ada.ID {
+			t.Errorf("Delete() owner = %d, want %d", userID, ada.ID)
+		}
+		if _, err = s.galleries.Delete(ctx, holidays.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("Delete() missing error = %v, want ErrNotFound", err)
+		}
+
+		galleries, err = s.galleries.All(ctx)
+		if err != nil {
+			t.Fatalf("All() error = %v", err)
+		}
+		if len(galleries) != 1 || galleries[0].ID != cats.ID {
+			t.Errorf("All() = %+v, want only %q", galleries, cats.Title)
+		}
+	})
+}
+
+func TestEmailOutbox(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		email := models.Email{From: "support@example.com", To: "ada@example.com", Subject: "Hi", Plaintext: "Hi"}
+		if err := s.outbox.Queue(ctx, models.OutboxMessage{Email: email, MaxAttempts: 3}); err != nil {
+			t.Fatalf("Queue() error = %v", err)
+		}
+
+		// A zero lease expires right away, as if the dispatcher had stopped.
+		claimed, err := s.outbox.Claim(ctx, 10, 0)
+		if err != nil {
+			t.Fatalf("Claim() error = %v", err)
+		}
+		if len(claimed) != 1 || claimed[0].Email != email || claimed[0].MaxAttempts != 3 {
+			t.Fatalf("Claim() = %+v, want the queued email", claimed)
+		}
+		if claimed, _ = s.outbox.Claim(ctx, 10, time.Hour); len(claimed) != 1 {
+			t.Fatalf("Claim() after expired lease = %+v, want the email again", claimed)
+		}
+		id := claimed[0].ID
+		if claimed, _ = s.outbox.Claim(ctx, 10, time.Hour); len(claimed) != 0 {
+			t.Errorf("Claim() during lease = %+v, want none", claimed)
+		}
+
+		if err = s.outbox.MarkRetry(ctx, id, 1, "timeout", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("MarkRetry() error = %v", err)
+		}
+		if claimed, _ = s.outbox.Claim(ctx, 10, time.Hour); len(claimed) != 0 {
+			t.Errorf("Claim() before next attempt = %+v, want none", claimed)
+		}
+		if count, _ := s.outbox.Count(ctx, models.EmailPending); count != 1 {
+			t.Errorf("Count(pending) after MarkRetry() = %d, want 1", count)
+		}
+
+		// Only claimed emails are marked, the retry above released this one.
+		if err = s.outbox.MarkSent(ctx, id, 2); err != nil {
+			t.Fatalf("MarkSent() error = %v", err)
+		}
+		if count, _ := s.outbox.Count(ctx, models.EmailSent); count != 0 {
+			t.Errorf("Count(sent) after MarkSent() of unclaimed email = %d, want 0", count)
+		}
+	})
+}
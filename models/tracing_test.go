@@ -0,0 +1,66 @@
+package models_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/models"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpansNestUnderCaller(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, err := database.NewSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	user := createUser(t, models.NewSQLiteUserStore(db), "ada@example.com")
+	galleries := models.NewGalleryService(models.NewSQLiteGalleryStore(db), nil)
+	galleries.ImageDir = t.TempDir()
+	transport := &models.MemoryTransport{}
+	emails := &models.EmailService{Outbox: models.NewMemoryEmailOutbox(), Transport: transport}
+	opened := len(recorder.Ended())
+
+	ctx, request := otel.Tracer("test").Start(context.Background(), "request")
+	gallery, err := galleries.Create(ctx, "Holidays", user.ID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err = galleries.CreateImage(ctx, gallery.ID, "beach.png", bytes.NewReader(pngImage(t, 1))); err != nil {
+		t.Fatalf("CreateImage() error = %v", err)
+	}
+	if err = galleries.Delete(ctx, gallery.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err = emails.Send(ctx, models.Email{To: "ada@example.com", Subject: "Hi", Plaintext: "Hi"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if _, err = emails.Dispatch(ctx, 1); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	request.End()
+
+	var names []string
+	for _, span := range recorder.Ended()[opened:] {
+		if span.SpanContext().SpanID() == request.SpanContext().SpanID() {
+			continue
+		}
+		names = append(names, span.Name())
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("span %q has parent %s, want the request span", span.Name(), span.Parent().SpanID())
+		}
+	}
+	for _, want := range []string{"sql.conn.query", "storage.write", "storage.remove_all", "email.send"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("no %q span recorded, got %v", want, names)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package models
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azdanov/imago/rand"
+)
+
+const (
+	// DefaultMaxUploadSize is the largest resumable upload accepted when MaxSize is not set.
+	DefaultMaxUploadSize = 50 << 20 // 50 MB
+	// DefaultUploadLifetime is how long an unfinished upload is kept before it is removed.
+	DefaultUploadLifetime = 24 * time.Hour
+
+	uploadIDBytes = 16
+)
+
+var (
+	ErrUploadTooLarge       = errors.New("models: upload too large")
+	ErrUploadOffsetMismatch = errors.New("models: upload offset mismatch")
+	ErrUploadLocked         = errors.New("models: upload is locked")
+)
+
+// Upload is a partial file that is received in chunks and can be resumed after
+// a dropped connection.
+type Upload struct {
+	ID        string    `json:"id"`
+	GalleryID int       `json:"gallery_id"`
+	UserID    int       `json:"user_id"`
+	Filename  string    `json:"filename"`
+	Length    int64     `json:"length"`
+	CreatedAt time.Time `json:"created_at"`
+	// Offset is the number of bytes received so far. It is derived from the data
+	// file on disk rather than stored.
+	Offset int64 `json:"-"`
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// UploadService stores partial uploads on disk. Each upload is kept as a data file
+// with a JSON info file next to it. Finished uploads are handed to GalleryService.CreateImage,
+// so they go through the same validation as regular uploads.
+type UploadService struct {
+	GalleryService *GalleryService
+	// Dir is where partial uploads are stored. Defaults to "uploads".
+	Dir string
+	// MaxSize is the largest upload accepted. Defaults to DefaultMaxUploadSize.
+	MaxSize int64
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewUploadService(gs *GalleryService, maxSize int64) *UploadService {
+	return &UploadService{
+		GalleryService: gs,
+		MaxSize:        maxSize,
+	}
+}
+
+func (s *UploadService) MaxUploadSize() int64 {
+	if s.MaxSize == 0 {
+		return DefaultMaxUploadSize
+	}
+	return s.MaxSize
+}
+
+func (s *UploadService) Create(galleryID, userID int, filename string, length int64) (*Upload, error) {
+	if length > s.MaxUploadSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	err := checkExtension(filename, s.GalleryService.Extensions())
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	id, err := rand.Bytes(uploadIDBytes)
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	upload := Upload{
+		ID:        hex.EncodeToString(id),
+		GalleryID: galleryID,
+		UserID:    userID,
+		Filename:  filepath.Base(filename),
+		Length:    length,
+		CreatedAt: time.Now(),
+	}
+
+	if err = os.MkdirAll(s.dir(), 0o750); err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+	if err = os.WriteFile(s.infoPath(upload.ID), info, 0o600); err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	data, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+	if err = data.Close(); err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	return &upload, nil
+}
+
+func (s *UploadService) ByID(id string) (*Upload, error) {
+	if !validUploadID(id) {
+		return nil, ErrNotFound
+	}
+
+	info, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("upload by id: %w", err)
+	}
+
+	var upload Upload
+	if err = json.Unmarshal(info, &upload); err != nil {
+		return nil, fmt.Errorf("upload by id: %w", err)
+	}
+
+	stat, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("upload by id: %w", err)
+	}
+	upload.Offset = stat.Size()
+
+	return &upload, nil
+}
+
+// Append writes a chunk starting at offset, which must match the bytes already
+// received. It reads at most the remaining length of the upload and returns the new
+// offset, even when the chunk was cut short by a dropped connection.
+func (s *UploadService) Append(upload *Upload, offset int64, r io.Reader) (int64, error) {
+	unlock, ok := s.lock(upload.ID)
+	if !ok {
+		return upload.Offset, ErrUploadLocked
+	}
+	defer unlock()
+
+	// Re-read the offset while holding the lock, another request may have written to it.
+	if err := s.refresh(upload); err != nil {
+		return upload.Offset, fmt.Errorf("append upload: %w", err)
+	}
+
+	if offset != upload.Offset {
+		return upload.Offset, ErrUploadOffsetMismatch
+	}
+
+	data, err := os.OpenFile(s.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return upload.Offset, fmt.Errorf("append upload: %w", err)
+	}
+	defer data.Close()
+
+	written, err := io.Copy(data, io.LimitReader(r, upload.Length-upload.Offset))
+	upload.Offset += written
+	if err != nil {
+		return upload.Offset, fmt.Errorf("append upload: %w", err)
+	}
+
+	return upload.Offset, nil
+}
+
+// Finish moves a complete upload into its gallery and removes the partial files.
+// The partial files are removed even if the image is rejected, since a complete
+// upload cannot be resumed. It holds the same lock as Append and removes the files
+// before releasing it, so a retried request finds the upload gone instead of adding
+// the image twice.
+func (s *UploadService) Finish(ctx context.Context, upload *Upload) error {
+	unlock, ok := s.lock(upload.ID)
+	if !ok {
+		return ErrUploadLocked
+	}
+	defer unlock()
+
+	if err := s.refresh(upload); err != nil {
+		return fmt.Errorf("finish upload: %w", err)
+	}
+	if !upload.Complete() {
+		return fmt.Errorf("finish upload: %w", ErrUploadOffsetMismatch)
+	}
+	// Deferred after unlock, so it runs first.
+	defer s.remove(upload.ID) //nolint:errcheck // best effort cleanup
+
+	data, err := os.Open(s.dataPath(upload.ID))
+	if err != nil {
+		return fmt.Errorf("finish upload: %w", err)
+	}
+	defer data.Close()
+
+	return s.GalleryService.CreateImage(ctx, upload.GalleryID, upload.Filename, data)
+}
+
+// Delete removes an upload. It returns ErrUploadLocked while another request is
+// writing to it.
+func (s *UploadService) Delete(id string) error {
+	if !validUploadID(id) {
+		return ErrNotFound
+	}
+
+	unlock, ok := s.lock(id)
+	if !ok {
+		return ErrUploadLocked
+	}
+	defer unlock()
+
+	return s.remove(id)
+}
+
+// DeleteExpired removes unfinished uploads older than maxAge. Uploads that are being
+// written to are skipped.
+func (s *UploadService) DeleteExpired(maxAge time.Duration) error {
+	infos, err := filepath.Glob(filepath.Join(s.dir(), "*.info"))
+	if err != nil {
+		return fmt.Errorf("delete expired uploads: %w", err)
+	}
+
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".info")
+		upload, uploadErr := s.ByID(id)
+		if uploadErr != nil && !errors.Is(uploadErr, ErrNotFound) {
+			return fmt.Errorf("delete expired uploads: %w", uploadErr)
+		}
+		if upload != nil && time.Since(upload.CreatedAt) < maxAge {
+			continue
+		}
+		err = s.Delete(id)
+		if errors.Is(err, ErrUploadLocked) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("delete expired uploads: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the files of an upload and forgets its lock. The caller must hold
+// the lock, so no other request can be holding a lock that is no longer in the map.
+func (s *UploadService) remove(id string) error {
+	for _, p := range []string{s.dataPath(id), s.infoPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("delete upload: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// lock guards an upload against concurrent writes and removal. It does not block, a
+// second writer is turned away instead. The lock only covers this process: when
+// several instances share the uploads directory, requests for an upload must be
+// routed to the same instance, for example with sticky sessions.
+func (s *UploadService) lock(id string) (func(), bool) {
+	// Taken while holding mu, so remove cannot drop the entry in between.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks == nil {
+		s.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	if !l.TryLock() {
+		return nil, false
+	}
+
+	return l.Unlock, true
+}
+
+// refresh reads the offset of upload from its data file. It returns ErrNotFound once
+// the upload is finished or deleted.
+func (s *UploadService) refresh(upload *Upload) error {
+	stat, err := os.Stat(s.dataPath(upload.ID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	upload.Offset = stat.Size()
+	return nil
+}
+
+func (s *UploadService) dir() string {
+	if s.Dir == "" {
+		return "uploads"
+	}
+	return s.Dir
+}
+
+func (s *UploadService) dataPath(id string) string {
+	return filepath.Join(s.dir(), id+".bin")
+}
+
+func (s *UploadService) infoPath(id string) string {
+	return filepath.Join(s.dir(), id+".info")
+}
+
+func validUploadID(id string) bool {
+	if len(id) != hex.EncodedLen(uploadIDBytes) {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,116 @@
+package models_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/azdanov/imago/models"
+)
+
+func TestUploadServiceFinishOnce(t *testing.T) {
+	ctx := context.Background()
+	galleries := models.NewGalleryService(models.NewMemoryGalleryStore(), nil)
+	galleries.ImageDir = t.TempDir()
+	uploads := models.NewUploadService(galleries, 0)
+	uploads.Dir = t.TempDir()
+
+	gallery, err := galleries.Create(ctx, "Holidays", 1)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	image := pngImage(t, 1)
+	upload, err := uploads.Create(gallery.ID, 1, "beach.png", int64(len(image)))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err = uploads.Append(upload, 0, bytes.NewReader(image)); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	// Requests retrying the last chunk each hold their own copy of the upload.
+	const requests = 8
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retry := *upload
+			errs[i] = uploads.Finish(ctx, &retry)
+		}()
+	}
+	wg.Wait()
+
+	finished := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			finished++
+		case errors.Is(err, models.ErrUploadLocked), errors.Is(err, models.ErrNotFound):
+		default:
+			t.Errorf("Finish() error = %v, want nil, ErrUploadLocked or ErrNotFound", err)
+		}
+	}
+	if finished != 1 {
+		t.Errorf("Finish() succeeded %d times, want 1", finished)
+	}
+
+	images, err := galleries.Images(ctx, gallery.ID)
+	if err != nil {
+		t.Fatalf("Images() error = %v", err)
+	}
+	if len(images) != 1 {
+		t.Errorf("Images() = %d images, want 1", len(images))
+	}
+
+	if err = uploads.Finish(ctx, upload); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("Finish() after finishing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUploadServiceDeleteWhileAppending(t *testing.T) {
+	galleries := models.NewGalleryService(models.NewMemoryGalleryStore(), nil)
+	uploads := models.NewUploadService(galleries, 0)
+	uploads.Dir = t.TempDir()
+
+	upload, err := uploads.Create(1, 1, "beach.png", 8)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Append holds the lock until the connection sends the rest of the chunk.
+	pr, pw := io.Pipe()
+	appended := make(chan error, 1)
+	go func() {
+		_, err := uploads.Append(upload, 0, pr)
+		appended <- err
+	}()
+	if _, err = pw.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = uploads.Delete(upload.ID); !errors.Is(err, models.ErrUploadLocked) {
+		t.Errorf("Delete() while appending error = %v, want ErrUploadLocked", err)
+	}
+	if err = uploads.DeleteExpired(0); err != nil {
+		t.Errorf("DeleteExpired() error = %v", err)
+	}
+	if _, err = uploads.ByID(upload.ID); err != nil {
+		t.Errorf("ByID() error = %v, want the upload kept while appending", err)
+	}
+
+	pw.Close()
+	if err = <-appended; err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err = uploads.DeleteExpired(0); err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if _, err = uploads.ByID(upload.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("ByID() after DeleteExpired() error = %v, want ErrNotFound", err)
+	}
+}
@@ -1,32 +1,76 @@
 package models
 
 import (
-	"database/sql"
-	"errors"
+	"context"
 	"fmt"
+	"time"
 
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// MinPasswordLength is the shortest password a user may choose.
+const MinPasswordLength = 8
+
 type User struct {
 	ID           int    `json:"id"`
 	Email        string `json:"email"`
 	PasswordHash string `json:"-"`
+	Role         Role   `json:"role"`
+	// Locale is the language the user receives emails in.
+	Locale     string     `json:"locale"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// DeletionRequestedAt is set while the account waits out the grace period
+	// before it is permanently deleted.
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+func (u *User) IsPendingDeletion() bool {
+	return u.DeletionRequestedAt != nil
+}
+
+// UserStore persists users. Lookups return ErrNotFound when no user matches.
+type UserStore interface {
+	// Create stores a new user and sets its ID and CreatedAt. It returns
+	// ErrEmailAlreadyExists when the email is taken.
+	Create(ctx context.Context, user *User) error
+	ByID(ctx context.Context, id int) (*User, error)
+	ByEmail(ctx context.Context, email string) (*User, error)
+	// Search returns users whose email contains term, ignoring case, ordered by ID.
+	Search(ctx context.Context, term string) ([]User, error)
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	UpdateLocale(ctx context.Context, id int, locale string) error
+	// Disable marks the user disabled, keeping the time of an earlier call.
+	Disable(ctx context.Context, id int) error
+	Enable(ctx context.Context, id int) error
 }
 
 type UserService struct {
-	DB *sql.DB
+	Store UserStore
 }
 
-func NewUserService(db *sql.DB) *UserService {
+func NewUserService(store UserStore) *UserService {
 	return &UserService{
-		DB: db,
+		Store: store,
 	}
 }
 
-func (us *UserService) Create(email, password string) (*User, error) {
+func (us *UserService) Create(ctx context.Context, email, password string) (*User, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("create: %w", err)
@@ -35,32 +79,21 @@ func (us *UserService) Create(email, password string) (*User, error) {
 	u := User{
 		Email:        email,
 		PasswordHash: string(hash),
+		Role:         RoleUser,
+		Locale:       DefaultLocale,
 	}
 
-	query := `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`
-	err = us.DB.QueryRow(query, u.Email, u.PasswordHash).Scan(&u.ID)
+	err = us.Store.Create(ctx, &u)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			return nil, ErrEmailAlreadyExists
-		}
 		return nil, fmt.Errorf("create: %w", err)
 	}
 
 	return &u, nil
 }
 
-func (us *UserService) Authenticate(email, password string) (*User, error) {
-	u := User{
-		Email: email,
-	}
-
-	query := `SELECT id, password_hash FROM users WHERE email = $1`
-	err := us.DB.QueryRow(query, email).Scan(&u.ID, &u.PasswordHash)
+func (us *UserService) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	u, err := us.Store.ByEmail(ctx, email)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("authenticate: %w", err)
 	}
 
@@ -69,20 +102,80 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 		return nil, fmt.Errorf("authenticate: %w", err)
 	}
 
-	return &u, nil
+	if u.IsDisabled() {
+		return nil, ErrAccountDisabled
+	}
+
+	return u, nil
 }
 
-func (us *UserService) UpdatePassword(userID int, password string) error {
+func (us *UserService) UpdatePassword(ctx context.Context, userID int, password string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("update password: %w", err)
 	}
 
-	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
-	_, err = us.DB.Exec(query, hash, userID)
-	if err != nil {
+	if err = us.Store.UpdatePassword(ctx, userID, string(hash)); err != nil {
 		return fmt.Errorf("update password: %w", err)
 	}
 
 	return nil
 }
+
+func (us *UserService) ByID(ctx context.Context, id int) (*User, error) {
+	u, err := us.Store.ByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("by id: %w", err)
+	}
+
+	return u, nil
+}
+
+// ByEmail returns the user with the email, or ErrNotFound.
+func (us *UserService) ByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := us.Store.ByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("by email: %w", err)
+	}
+
+	return u, nil
+}
+
+// Search returns users whose email contains the given term, ordered by ID.
+// An empty term matches every user.
+func (us *UserService) Search(ctx context.Context, term string) ([]User, error) {
+	users, err := us.Store.Search(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	return users, nil
+}
+
+func (us *UserService) Disable(ctx context.Context, id int) error {
+	if err := us.Store.Disable(ctx, id); err != nil {
+		return fmt.Errorf("disable: %w", err)
+	}
+
+	return nil
+}
+
+func (us *UserService) Enable(ctx context.Context, id int) error {
+	if err := us.Store.Enable(ctx, id); err != nil {
+		return fmt.Errorf("enable: %w", err)
+	}
+
+	return nil
+}
+
+func (us *UserService) UpdateLocale(ctx context.Context, id int, locale string) error {
+	if _, ok := Locales[locale]; !ok {
+		return ErrUnsupportedLocale
+	}
+
+	if err := us.Store.UpdateLocale(ctx, id, locale); err != nil {
+		return fmt.Errorf("update locale: %w", err)
+	}
+
+	return nil
+}
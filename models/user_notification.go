@@ -0,0 +1,315 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DefaultNotificationLimit is the number of notifications returned by listing methods
+// when no limit is given.
+const DefaultNotificationLimit = 50
+
+type NotificationKind string
+
+const (
+	NotificationExportReady NotificationKind = "export.ready"
+)
+
+// NotificationKinds lists every kind in the order shown to users. Add a kind only
+// together with the code publishing it.
+var NotificationKinds = []NotificationKind{
+	NotificationExportReady,
+}
+
+// Label is the user-facing name of the kind.
+func (k NotificationKind) Label() string {
+	switch k {
+	case NotificationExportReady:
+		return "Finished data exports"
+	default:
+		return string(k)
+	}
+}
+
+// RequiresEmail reports whether the kind is always emailed. A finished export is,
+// because its download link is only ever sent by email.
+func (k NotificationKind) RequiresEmail() bool {
+	return k == NotificationExportReady
+}
+
+// OptionalNotificationKinds returns the kinds users can stop receiving by email, in
+// the order shown to users. While it is empty there are no email preferences to
+// show or store.
+func OptionalNotificationKinds() []NotificationKind {
+	var kinds []NotificationKind
+	for _, kind := range NotificationKinds {
+		if !kind.RequiresEmail() {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// UserNotification is a message kept in a user's notification center until they
+// read it. Unlike a Notification it outlives the request that created it.
+type UserNotification struct {
+	ID      int64            `json:"id"`
+	UserID  int              `json:"user_id"`
+	Kind    NotificationKind `json:"kind"`
+	Message string           `json:"message"`
+	// Link is a path on this site with more details. It may be empty.
+	Link      string     `json:"link,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (n UserNotification) IsRead() bool {
+	return n.ReadAt != nil
+}
+
+// NotificationService stores notifications and emails them to users who have not
+// opted out of their kind. Other services publish through Publish. Its queries run
+// on SQLite as well, times are passed in rather than taken from NOW().
+type NotificationService struct {
+	DB           *sql.DB
+	EmailService *EmailService
+	// Events receives an event for every published notification. It may be nil.
+	Events *EventBroker
+	// BaseURL is prepended to notification links in emails.
+	BaseURL string
+}
+
+func NewNotificationService(db *sql.DB, es *EmailService, eb *EventBroker, baseURL string) *NotificationService {
+	return &NotificationService{
+		DB:           db,
+		EmailService: es,
+		Events:       eb,
+		BaseURL:      baseURL,
+	}
+}
+
+// Publish stores the notification and, if the user wants emails for its kind, queues
+// an email in the same transaction. A nil email sends the generic EmailNotification
+// email; publishers with a dedicated template pass their own.
+func (s *NotificationService) Publish(ctx context.Context, n *UserNotification, email *Email) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("publish notification: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	n.CreatedAt = time.Now()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, kind, message, link, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`, n.UserID, n.Kind, n.Message, n.Link, n.CreatedAt,
+	).Scan(&n.ID)
+	if err != nil {
+		return fmt.Errorf("publish notification: %w", err)
+	}
+
+	var to, locale string
+	var wantsEmail bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT users.email, users.locale, COALESCE(notification_preferences.email, TRUE)
+		FROM users
+		LEFT JOIN notification_preferences
+			ON notification_preferences.user_id = users.id AND notification_preferences.kind = $2
+		WHERE users.id = $1;`, n.UserID, n.Kind,
+	).Scan(&to, &locale, &wantsEmail)
+	if err != nil {
+		return fmt.Errorf("publish notification: %w", err)
+	}
+
+	if wantsEmail || n.Kind.RequiresEmail() {
+		if email == nil {
+			link := n.Link
+			if link == "" {
+				link = "/notifications"
+			}
+			rendered, err := s.EmailService.Render(to, locale, EmailNotification, NotificationData{
+				Message: n.Message,
+				URL:     s.BaseURL + link,
+			})
+			if err != nil {
+				return fmt.Errorf("publish notification: %w", err)
+			}
+			email = &rendered
+		}
+		if err = s.EmailService.SendTx(ctx, tx, *email); err != nil {
+			return fmt.Errorf("publish notification: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("publish notification: %w", err)
+	}
+
+	s.Events.Publish(ctx, UserTopic(n.UserID), EventNotificationCreated, n)
+
+	return nil
+}
+
+// ByUserID returns the user's most recent notifications, newest first.
+func (s *NotificationService) ByUserID(ctx context.Context, userID int, limit int) ([]UserNotification, error) {
+	if limit <= 0 {
+		limit = DefaultNotificationLimit
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, user_id, kind, message, link, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2;`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query notifications by user: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []UserNotification
+	for rows.Next() {
+		var n UserNotification
+		err = rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Message, &n.Link, &n.ReadAt, &n.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan notification row: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate notification rows: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// Unread returns the number of notifications the user has not read yet.
+func (s *NotificationService) Unread(ctx context.Context, userID int) (int, error) {
+	var count int
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL;`,
+		userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count unread notifications: %w", err)
+	}
+
+	return count, nil
+}
+
+// MarkRead marks one of the user's notifications as read. It returns ErrNotFound if
+// the notification does not exist or belongs to someone else.
+func (s *NotificationService) MarkRead(ctx context.Context, userID int, id int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, $3)
+		WHERE id = $1 AND user_id = $2;`, id, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("mark notification read: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("mark notification read: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *NotificationService) MarkAllRead(ctx context.Context, userID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `UPDATE notifications SET read_at = $2 WHERE user_id = $1 AND read_at IS NULL;`,
+		userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("mark all notifications read: %w", err)
+	}
+
+	return nil
+}
+
+// EmailPreferences returns whether the user wants emails, per kind. Kinds without a
+// stored preference default to true.
+func (s *NotificationService) EmailPreferences(ctx context.Context, userID int) (map[NotificationKind]bool, error) {
+	preferences := map[NotificationKind]bool{}
+	for _, kind := range NotificationKinds {
+		preferences[kind] = true
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT kind, email FROM notification_preferences WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind NotificationKind
+		var email bool
+		if err = rows.Scan(&kind, &email); err != nil {
+			return nil, fmt.Errorf("scan notification preference row: %w", err)
+		}
+		preferences[kind] = email
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate notification preference rows: %w", err)
+	}
+
+	return preferences, nil
+}
+
+// UpdateEmailPreferences stores whether the user wants emails for each given kind.
+// Unknown kinds are rejected.
+func (s *NotificationService) UpdateEmailPreferences(
+	ctx context.Context,
+	userID int,
+	preferences map[NotificationKind]bool,
+) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("update notification preferences: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	for kind, email := range preferences {
+		if !slices.Contains(NotificationKinds, kind) {
+			return fmt.Errorf("update notification preferences: unknown kind %q", kind)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO notification_preferences (user_id, kind, email)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, kind) DO UPDATE SET email = EXCLUDED.email;`, userID, kind, email)
+		if err != nil {
+			return fmt.Errorf("update notification preferences: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("update notification preferences: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/models"
+)
+
+func TestNotificationService(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := models.NewSQLiteUserStore(db)
+	ada := createUser(t, users, "ada@example.com")
+	grace := createUser(t, users, "grace@example.com")
+	outbox := models.NewSQLiteEmailOutbox(db)
+	emails := &models.EmailService{Outbox: outbox, Templates: parseEmailTemplates(t)}
+	notifications := models.NewNotificationService(db, emails, nil, "http://localhost:3000")
+
+	// Kinds without a publisher cannot be opted out of through the service, so the
+	// preferences of the user are stored directly.
+	quiet, chatty := models.NotificationKind("test.quiet"), models.NotificationKind("test.chatty")
+	query := `INSERT INTO notification_preferences (user_id, kind, email) VALUES ($1, $2, FALSE), ($1, $3, FALSE)`
+	_, err = db.Exec(query, ada.ID, quiet, models.NotificationExportReady)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(userID int, kind models.NotificationKind) *models.UserNotification {
+		t.Helper()
+
+		n := &models.UserNotification{UserID: userID, Kind: kind, Message: "Something happened", Link: "/users/me"}
+		if err := notifications.Publish(ctx, n, nil); err != nil {
+			t.Fatalf("Publish(%s) error = %v", kind, err)
+		}
+		if n.ID == 0 || n.CreatedAt.IsZero() {
+			t.Errorf("Publish() did not set ID and CreatedAt: %+v", n)
+		}
+		return n
+	}
+	first := publish(ada.ID, quiet)
+	publish(ada.ID, chatty)
+	publish(ada.ID, models.NotificationExportReady)
+	other := publish(grace.ID, chatty)
+
+	// The opted out kind is not emailed, unless it always is like a finished export.
+	if queued, _ := outbox.Count(ctx, models.EmailPending); queued != 3 {
+		t.Errorf("queued %d emails, want 3 of the 4 notifications", queued)
+	}
+
+	list, err := notifications.ByUserID(ctx, ada.ID, 0)
+	if err != nil {
+		t.Fatalf("ByUserID() error = %v", err)
+	}
+	if len(list) != 3 || list[0].Kind != models.NotificationExportReady {
+		t.Errorf("ByUserID() = %+v, want ada's 3 notifications newest first", list)
+	}
+
+	if err = notifications.MarkRead(ctx, ada.ID, first.ID); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if err = notifications.MarkRead(ctx, ada.ID, other.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("MarkRead() of another user's notification error = %v, want ErrNotFound", err)
+	}
+	if unread, _ := notifications.Unread(ctx, ada.ID); unread != 2 {
+		t.Errorf("Unread() after MarkRead() = %d, want 2", unread)
+	}
+
+	if err = notifications.MarkAllRead(ctx, ada.ID); err != nil {
+		t.Fatalf("MarkAllRead() error = %v", err)
+	}
+	if unread, _ := notifications.Unread(ctx, ada.ID); unread != 0 {
+		t.Errorf("Unread() after MarkAllRead() = %d, want 0", unread)
+	}
+	if unread, _ := notifications.Unread(ctx, grace.ID); unread != 1 {
+		t.Errorf("Unread() of another user = %d, want 1", unread)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/logging"
+	"github.com/azdanov/imago/metrics"
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/server"
+	"github.com/azdanov/imago/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const (
+	readTimeout  = 15 * time.Second
+	writeTimeout = 15 * time.Second
+	idleTimeout  = 60 * time.Second
+
+	maintenanceInterval = 1 * time.Hour
+	jobConcurrency      = 4
+	jobDrainTimeout     = 30 * time.Second
+	traceFlushTimeout   = 5 * time.Second
+)
+
+// serve runs the web server until it receives SIGINT or SIGTERM. Pending migrations are
+// applied first, unless DB_AUTO_MIGRATE is off.
+func serve(fs *flag.FlagSet, args []string) error {
+	// Load configuration from the config file, environment variables and flags
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	cnf, err := config.Load(fs, args)
+	if err != nil {
+		return err
+	}
+	if *printConfig {
+		return cnf.Print(os.Stdout)
+	}
+
+	// Setup logging, JSON in production and text in development
+	slog.SetDefault(logging.New(os.Stdout, cnf.Server.Env, cnf.Log.Level))
+
+	// Setup tracing before anything that records spans
+	flushTraces, err := tracing.Setup(context.Background(), cnf.Tracing)
+	if err != nil {
+		return fmt.Errorf("unable to setup tracing: %w", err)
+	}
+
+	// Setup database
+	db, err := setupDatabase(context.Background(), cnf)
+	if err != nil {
+		return err
+	}
+
+	// Setup services
+	services, err := server.NewServices(db, cnf)
+	if err != nil {
+		return fmt.Errorf("unable to setup services: %w", err)
+	}
+	registerMetrics(db, services)
+
+	// Run background jobs
+	worker := setupJobs(services, cnf)
+	worker.Start()
+
+	// Deliver queued emails
+	dispatcher := models.NewEmailDispatcher(services.EmailService)
+	dispatcher.Start()
+
+	// Share live updates with other instances
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	if services.EventRelay != nil {
+		go services.EventRelay.Listen(eventsCtx)
+	}
+
+	// Setup router and routes
+	health := server.NewHealth(cnf, db, services)
+	r := server.NewRouter(cnf, services, health)
+
+	// Start server
+	slog.Info("Starting server", "url", cnf.Server.GetURL())
+	srv := &http.Server{
+		Handler:      r,
+		Addr:         cnf.Server.GetAddr(),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// Wait for a shutdown signal, or for the server to fail
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	case err = <-serveErr:
+		err = fmt.Errorf("unable to start server: %w", err)
+	}
+	stop()
+
+	shutdown(cnf, srv, health, worker, dispatcher, stopEvents, services, db, flushTraces)
+	return err
+}
+
+// shutdown stops the server in the reverse order of startup. Readiness turns false
+// first, so no new traffic arrives while in-flight requests, jobs and email
+// deliveries are given time to finish. Every step runs even if an earlier one fails.
+func shutdown(
+	cnf *config.Config,
+	srv *http.Server,
+	health *controllers.Health,
+	worker *models.JobWorker,
+	dispatcher *models.EmailDispatcher,
+	stopEvents context.CancelFunc,
+	s *server.Services,
+	db *sql.DB,
+	flushTraces func(context.Context) error,
+) {
+	health.Drain()
+	if cnf.Server.DrainDelay > 0 {
+		slog.Info("Reporting not ready before closing connections", "delay", cnf.Server.DrainDelay)
+		time.Sleep(cnf.Server.DrainDelay)
+	}
+
+	// End open event streams, browsers reconnect to another instance
+	stopEvents()
+	s.EventBroker.Close()
+
+	slog.Info("Waiting for in-flight requests to finish")
+	ctx, cancel := context.WithTimeout(context.Background(), cnf.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Unable to drain requests", "error", err)
+	}
+
+	slog.Info("Waiting for running jobs to finish")
+	ctx, cancel = context.WithTimeout(context.Background(), jobDrainTimeout)
+	defer cancel()
+	if err := worker.Shutdown(ctx); err != nil {
+		slog.Error("Unable to drain jobs", "error", err)
+	}
+	if err := dispatcher.Shutdown(ctx); err != nil {
+		slog.Error("Unable to drain email dispatcher", "error", err)
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Unable to close database", "error", err)
+	}
+
+	// Jobs may have used up their drain window, the last spans still get time to export.
+	ctx, cancel = context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := flushTraces(ctx); err != nil {
+		slog.Error("Unable to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// setupDatabase connects to the database and, unless turned off, applies pending
+// migrations. It fails when the schema is older than the migrations of this build,
+// since the queries rely on them.
+func setupDatabase(ctx context.Context, cnf *config.Config) (*sql.DB, error) {
+	db, err := database.NewDB(cnf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+
+	if cnf.DB.AutoMigrate {
+		err = database.Migrate(ctx, db, database.FS, database.MigrationsDir)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to migrate database: %w", err)
+		}
+	}
+
+	current, latest, err := database.Versions(ctx, db, database.FS, database.MigrationsDir)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to check schema version: %w", err)
+	}
+	switch {
+	case current < latest:
+		db.Close()
+		return nil, fmt.Errorf(
+			"database schema is at version %d, this build needs %d: run imago migrate up",
+			current, latest,
+		)
+	case current > latest:
+		// Expected during a rolling deploy, while older instances are being replaced.
+		slog.Warn("Database schema is newer than this build", "version", current, "latest", latest)
+	}
+
+	return db, nil
+}
+
+// registerMetrics exports the database pool statistics and the gauges that are read
+// from the database on every scrape.
+func registerMetrics(db *sql.DB, s *server.Services) {
+	metrics.Registry.MustRegister(
+		collectors.NewDBStatsCollector(db, "postgres"),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "imago",
+			Name:      "sessions",
+			Help:      "Active sessions.",
+		}, func() float64 {
+			count, err := s.SessionService.Count(context.Background())
+			if err != nil {
+				slog.Error("Unable to count sessions", "error", err)
+				return 0
+			}
+			return float64(count)
+		}),
+	)
+}
+
+// setupJobs registers the background job handlers. Maintenance runs as recurring jobs,
+// so only one instance performs it when several are running.
+func setupJobs(s *server.Services, cnf *config.Config) *models.JobWorker {
+	w := models.NewJobWorker(s.JobService, jobConcurrency)
+
+	w.Register(models.JobBuildDataExport, models.HandleJob(
+		func(ctx context.Context, payload models.BuildDataExportJob) error {
+			export, err := s.DataExportService.ByID(ctx, payload.ExportID)
+			if err != nil {
+				return err
+			}
+			if err = s.DataExportService.Build(ctx, export); err != nil {
+				return err
+			}
+
+			downloadURL := cnf.Server.GetURL() + "/users/me/exports/" + url.PathEscape(export.Token)
+			email, err := s.EmailService.DataExportEmail(payload.Email, payload.Locale, downloadURL, export.ExpiresAt)
+			if err != nil {
+				return err
+			}
+
+			// The link holds the download token, so it is only sent by email.
+			return s.NotificationService.Publish(ctx, &models.UserNotification{
+				UserID:  export.UserID,
+				Kind:    models.NotificationExportReady,
+				Message: "Your data export is ready. We have emailed you the download link.",
+				Link:    "/users/me",
+			}, &email)
+		}))
+
+	w.Every(models.JobPurgeDeletedAccounts, maintenanceInterval, func(ctx context.Context, _ *models.Job) error {
+		return purgeDeletedAccounts(ctx, s)
+	})
+	w.Every(models.JobDeleteExpiredExports, maintenanceInterval, func(ctx context.Context, _ *models.Job) error {
+		return s.DataExportService.DeleteExpired(ctx)
+	})
+	w.Every(models.JobDeleteExpiredUploads, maintenanceInterval, func(context.Context, *models.Job) error {
+		return s.UploadService.DeleteExpired(models.DefaultUploadLifetime)
+	})
+	w.Every(models.JobDeleteFinishedJobs, maintenanceInterval, func(ctx context.Context, _ *models.Job) error {
+		return s.JobService.DeleteFinished(ctx, models.DefaultFinishedJobMaxAge)
+	})
+	w.Every(models.JobDeleteSentEmails, maintenanceInterval, func(ctx context.Context, _ *models.Job) error {
+		return s.EmailService.DeleteSent(ctx, models.DefaultSentEmailMaxAge)
+	})
+	w.Every(models.JobDeleteOldAuditEvents, maintenanceInterval, func(ctx context.Context, _ *models.Job) error {
+		return s.AuditService.DeleteOlderThan(ctx, models.DefaultAuditMaxAge)
+	})
+
+	return w
+}
+
+// purgeDeletedAccounts removes accounts whose deletion grace period has passed. One
+// failing account does not stop the others from being purged.
+func purgeDeletedAccounts(ctx context.Context, s *server.Services) error {
+	userIDs, err := s.AccountDeletion.Due(ctx)
+	if err != nil {
+		return fmt.Errorf("list accounts due for deletion: %w", err)
+	}
+
+	var errs []error
+	for _, userID := range userIDs {
+		err = s.AccountDeletion.Purge(ctx, userID)
+		if errors.Is(err, models.ErrDeletionNotDue) {
+			// Cancelled since Due listed it.
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purge account %d: %w", userID, err))
+			continue
+		}
+
+		err = s.AuditService.Record(ctx, models.AuditEvent{
+			OwnerID: &userID,
+			Action:  models.AuditAccountDelete,
+			Target:  "user:" + strconv.Itoa(userID),
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("Unable to record account deletion", "error", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
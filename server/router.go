@@ -0,0 +1,222 @@
+package server
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/database"
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/templates"
+	"github.com/azdanov/imago/views"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/csrf"
+)
+
+// NewRouter returns the handler serving every route of the application.
+func NewRouter(cnf *config.Config, s *Services, health *controllers.Health) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(controllers.TracingMiddleware)
+	r.Use(middleware.RealIP)
+	r.Use(controllers.RequestLogger(slog.Default()))
+	r.Use(middleware.Recoverer)
+	r.Use(controllers.MetricsMiddleware)
+
+	// Probes and metrics are mounted before CSRF and session handling, so they never
+	// touch cookies or the sessions table. The metrics require the health token.
+	r.Get("/healthz", health.Live)
+	r.Get("/readyz", health.Ready)
+	r.With(health.Protect).Method(http.MethodGet, "/metrics", controllers.Metrics())
+
+	r.Group(func(r chi.Router) {
+		r.Use(csrf.Protect([]byte(cnf.CSRF.Key),
+			csrf.Secure(cnf.CSRF.Secure),
+			csrf.TrustedOrigins([]string{cnf.Server.GetAddr()}),
+		))
+
+		um := controllers.NewUserMiddleware(s.SessionService, s.SessionCookie)
+		r.Use(um.SetUser)
+
+		notificationMiddleware := controllers.NewNotificationMiddleware(
+			controllers.NewFlashCookie([]byte(cnf.Flash.Key), cnf.Flash.Secure),
+		)
+		r.Use(notificationMiddleware.ExtractNotifications)
+
+		notificationsC := controllers.NewNotificationCenter(s.NotificationService)
+		r.Use(notificationsC.SetUnreadCount)
+
+		routes(r, s, um, notificationsC, cnf)
+	})
+
+	return r
+}
+
+// NewHealth registers the readiness checks. SMTP is only checked when emails are
+// actually sent over SMTP, and never makes the server unavailable: emails wait in
+// the outbox until the mail server is back.
+func NewHealth(cnf *config.Config, db *sql.DB, s *Services) *controllers.Health {
+	h := controllers.NewHealth(cnf.Health.Token)
+	h.Checks["database"] = controllers.DatabaseCheck(db)
+	h.Checks["migrations"] = controllers.MigrationsCheck(db, database.FS, database.MigrationsDir)
+	h.Checks["storage"] = controllers.StorageCheck(s.GalleryService.ImagesDir())
+	if cnf.SMTP.Transport == config.SMTPTransportSMTP || cnf.SMTP.Transport == "" {
+		h.Noncritical["smtp"] = controllers.SMTPCheck(cnf.SMTP)
+	}
+
+	return h
+}
+
+func routes(
+	r chi.Router,
+	s *Services,
+	um *controllers.UserMiddleware,
+	notificationsC *controllers.NotificationCenter,
+	cnf *config.Config,
+) {
+	// Static routes
+	tmpl := views.Must(views.Parse(templates.FS, "home.tmpl.html"))
+	r.Method(http.MethodGet, "/", controllers.ResetLinkRedirect(controllers.StaticHandler(tmpl)))
+
+	tmpl = views.Must(views.Parse(templates.FS, "contact.tmpl.html"))
+	r.Get("/contact", controllers.StaticHandler(tmpl))
+
+	tmpl = views.Must(views.Parse(templates.FS, "faq.tmpl.html"))
+	r.Get("/faq", controllers.FAQ(tmpl))
+
+	// User routes
+	usersC := controllers.NewUsers(
+		s.UserService,
+		s.SessionService,
+		s.SessionCookie,
+		s.PasswordResetService,
+		s.EmailService,
+		s.JobService,
+		s.AuditService,
+		s.AccountDeletion,
+		s.DataExportService,
+		cnf,
+	)
+
+	usersC.Templates.SignUp = views.Must(views.Parse(templates.FS, "signup.tmpl.html"))
+	r.Get("/signup", usersC.NewSignup)
+	r.Post("/signup", usersC.HandleSignup)
+
+	usersC.Templates.SignIn = views.Must(views.Parse(templates.FS, "signin.tmpl.html"))
+	r.Get("/signin", usersC.NewSignin)
+	r.Post("/signin", usersC.HandleSignin)
+	r.Post("/signout", usersC.HandleSignout)
+
+	usersC.Templates.ForgotPassword = views.Must(views.Parse(templates.FS, "forgot_password.tmpl.html"))
+	r.Get("/forgot-password", usersC.NewForgotPassword)
+	r.Post("/forgot-password", usersC.HandleForgotPassword)
+
+	usersC.Templates.ResetPassword = views.Must(views.Parse(templates.FS, "reset_password.tmpl.html"))
+	r.Get("/reset-password", usersC.NewResetPassword)
+	r.Post("/reset-password", usersC.HandleResetPassword)
+
+	usersC.Templates.Me = views.Must(views.Parse(templates.FS, "me.tmpl.html"))
+	r.Route("/users/me", func(r chi.Router) {
+		r.Use(um.RequireUser)
+		r.Get("/", usersC.CurrentUser)
+		r.Post("/locale", usersC.HandleUpdateLocale)
+		r.Post("/delete", usersC.HandleDeleteAccount)
+		r.Post("/delete/cancel", usersC.HandleCancelDeleteAccount)
+		r.Post("/exports", usersC.HandleExportData)
+		r.Get("/exports/{token}", usersC.DownloadExport)
+	})
+
+	// Notification routes
+	notificationsC.Templates.Index = views.Must(views.Parse(templates.FS, "notifications.tmpl.html"))
+	r.Route("/notifications", func(r chi.Router) {
+		r.Use(um.RequireUser)
+		r.Get("/", notificationsC.Index)
+		r.Post("/read", notificationsC.MarkAllRead)
+		r.Post("/{id}/read", notificationsC.MarkRead)
+		// Every kind is always emailed until one can be turned off.
+		if len(models.OptionalNotificationKinds()) > 0 {
+			r.Post("/preferences", notificationsC.HandleUpdatePreferences)
+		}
+	})
+
+	// Live updates
+	eventsC := controllers.NewEvents(s.EventBroker, s.GalleryService)
+	r.With(um.RequireUser).Get("/events", eventsC.User)
+
+	// Gallery routes
+	galleriesC := controllers.NewGalleries(s.GalleryService, s.AuditService)
+	galleriesC.Templates.New = views.Must(views.Parse(templates.FS, "galleries/new.tmpl.html"))
+	galleriesC.Templates.Edit = views.Must(views.Parse(templates.FS, "galleries/edit.tmpl.html"))
+	galleriesC.Templates.Show = views.Must(views.Parse(templates.FS, "galleries/show.tmpl.html"))
+	galleriesC.Templates.List = views.Must(views.Parse(templates.FS, "galleries/list.tmpl.html"))
+	galleriesC.Templates.Import = views.Must(views.Parse(templates.FS, "galleries/import.tmpl.html"))
+	uploadsC := controllers.NewUploads(s.GalleryService, s.UploadService, s.AuditService)
+	r.Route("/galleries", func(r chi.Router) {
+		r.Get("/{id}", galleriesC.Show)
+		r.Get("/{id}/images/{filename}", galleriesC.Image)
+		r.Get("/{id}/download", galleriesC.Download)
+		r.Get("/{id}/events", eventsC.Gallery)
+		r.Group(func(r chi.Router) {
+			r.Use(um.RequireUser)
+			r.Get("/", galleriesC.List)
+			r.Get("/new", galleriesC.New)
+			r.Post("/", galleriesC.Create)
+			r.Get("/{id}/edit", galleriesC.Edit)
+			r.Post("/{id}", galleriesC.Update)
+			r.Post("/{id}/delete", galleriesC.Delete)
+			r.Post("/{id}/images", galleriesC.UploadImage)
+			r.Post("/{id}/import", galleriesC.ImportZip)
+			r.Post("/{id}/images/{filename}/delete", galleriesC.DeleteImage)
+		})
+		// Resumable uploads, see https://tus.io
+		r.Route("/{id}/uploads", func(r chi.Router) {
+			r.Use(um.RequireUser)
+			r.Use(uploadsC.RequireTusResumable)
+			r.Options("/", uploadsC.Options)
+			r.Post("/", uploadsC.Create)
+			r.Head("/{uploadID}", uploadsC.Head)
+			r.Patch("/{uploadID}", uploadsC.Patch)
+			r.Delete("/{uploadID}", uploadsC.Delete)
+		})
+	})
+
+	// Admin routes
+	adminC := controllers.NewAdmin(
+		s.UserService,
+		s.SessionService,
+		s.PasswordResetService,
+		s.EmailService,
+		s.GalleryService,
+		s.AuditService,
+		s.JobService,
+		cnf,
+	)
+	adminC.Templates.Users = views.Must(views.Parse(templates.FS, "admin/users.tmpl.html"))
+	adminC.Templates.Galleries = views.Must(views.Parse(templates.FS, "admin/galleries.tmpl.html"))
+	adminC.Templates.Audit = views.Must(views.Parse(templates.FS, "admin/audit.tmpl.html"))
+	adminC.Templates.Jobs = views.Must(views.Parse(templates.FS, "admin/jobs.tmpl.html"))
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(um.RequireUser)
+		r.Use(um.RequireAdmin)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/admin/users", http.StatusFound)
+		})
+		r.Get("/users", adminC.Users)
+		r.Post("/users/{id}/disable", adminC.DisableUser)
+		r.Post("/users/{id}/enable", adminC.EnableUser)
+		r.Post("/users/{id}/signout", adminC.SignOutUser)
+		r.Post("/users/{id}/reset-password", adminC.ResetUserPassword)
+		r.Get("/galleries", adminC.Galleries)
+		r.Post("/galleries/{id}/delete", adminC.DeleteGallery)
+		r.Get("/audit", adminC.Audit)
+		r.Get("/jobs", adminC.Jobs)
+		r.Post("/jobs/{id}/retry", adminC.RetryJob)
+	})
+
+	// 404 handler
+	tmpl = views.Must(views.Parse(templates.FS, "404.tmpl.html"))
+	r.NotFound(controllers.StaticHandler(tmpl))
+}
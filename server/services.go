@@ -0,0 +1,83 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+
+	"github.com/azdanov/imago/config"
+	"github.com/azdanov/imago/controllers"
+	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/templates"
+)
+
+// Services are the models shared by the routes and the background jobs.
+type Services struct {
+	SessionService       *models.SessionService
+	UserService          *models.UserService
+	SessionCookie        *controllers.SessionCookie
+	PasswordResetService *models.PasswordResetService
+	EmailService         *models.EmailService
+	GalleryService       *models.GalleryService
+	AuditService         *models.AuditService
+	AccountDeletion      *models.AccountDeletionService
+	DataExportService    *models.DataExportService
+	UploadService        *models.UploadService
+	JobService           *models.JobService
+	NotificationService  *models.NotificationService
+	EventBroker          *models.EventBroker
+	// EventRelay is nil unless events are shared through Postgres.
+	EventRelay *models.PostgresEventRelay
+}
+
+// NewServices creates the services on top of the Postgres database db.
+func NewServices(db *sql.DB, cnf *config.Config) (*Services, error) {
+	userStore := models.NewPostgresUserStore(db)
+	ss := models.NewSessionService(models.NewPostgresSessionStore(db), models.MinSessionTokenBytes)
+	us := models.NewUserService(userStore)
+	sc := controllers.NewSessionCookie(cnf.Server.SSLMode)
+	ps := models.NewPasswordResetService(models.NewPostgresPasswordResetStore(db), userStore,
+		models.MinSessionTokenBytes, models.DefaultTokenLifetime)
+	emailsFS, err := fs.Sub(templates.FS, "emails")
+	if err != nil {
+		return nil, fmt.Errorf("load email templates: %w", err)
+	}
+	es, err := models.NewEmailService(db, cnf, emailsFS)
+	if err != nil {
+		return nil, fmt.Errorf("create email service: %w", err)
+	}
+	eb := models.NewEventBroker()
+	var er *models.PostgresEventRelay
+	switch cnf.Events.Backend {
+	case config.EventBackendPostgres:
+		er = models.NewPostgresEventRelay(db, cnf.DB.GetDSN(), eb)
+		eb.Relay = er
+	case config.EventBackendMemory, "":
+	default:
+		return nil, fmt.Errorf("unknown events backend %q", cnf.Events.Backend)
+	}
+	gs := models.NewGalleryService(models.NewPostgresGalleryStore(db), eb)
+	as := models.NewAuditService(db)
+	ds := models.NewAccountDeletionService(db, gs, models.DefaultDeletionGracePeriod)
+	xs := models.NewDataExportService(db, gs, models.MinSessionTokenBytes, models.DefaultExportLifetime)
+	ups := models.NewUploadService(gs, models.DefaultMaxUploadSize)
+	js := models.NewJobService(db, models.DefaultJobMaxAttempts)
+	ns := models.NewNotificationService(db, es, eb, cnf.Server.GetURL())
+
+	return &Services{
+		SessionService:       ss,
+		UserService:          us,
+		SessionCookie:        sc,
+		PasswordResetService: ps,
+		EmailService:         es,
+		GalleryService:       gs,
+		AuditService:         as,
+		AccountDeletion:      ds,
+		DataExportService:    xs,
+		UploadService:        ups,
+		JobService:           js,
+		NotificationService:  ns,
+		EventBroker:          eb,
+		EventRelay:           er,
+	}, nil
+}
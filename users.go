@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azdanov/imago/models"
+)
+
+func userCreate(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	email := fs.Arg(0)
+	user, err := a.services.UserService.Create(context.Background(), email, password)
+	if errors.Is(err, models.ErrEmailAlreadyExists) {
+		return fmt.Errorf("a user with email %s already exists", email)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created user %d with email %s\n", user.ID, user.Email)
+
+	return nil
+}
+
+func userList(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	users, err := a.services.UserService.Search(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tROLE\tSTATUS\tCREATED AT")
+	for _, user := range users {
+		status := "active"
+		switch {
+		case user.IsDisabled():
+			status = "disabled"
+		case user.IsPendingDeletion():
+			status = "pending deletion"
+		}
+		createdAt := user.CreatedAt.Local().Format(time.DateTime)
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", user.ID, user.Email, user.Role, status, createdAt)
+	}
+
+	return tw.Flush()
+}
+
+// userSetPassword changes the password of a user and signs them out, like a password
+// reset.
+func userSetPassword(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx := context.Background()
+	user, err := a.userByEmail(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	if err = a.services.UserService.UpdatePassword(ctx, user.ID, password); err != nil {
+		return err
+	}
+	a.audit(ctx, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditPasswordReset,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	if err = a.revokeSessions(ctx, user); err != nil {
+		return err
+	}
+	fmt.Printf("Changed the password of %s\n", user.Email)
+
+	return nil
+}
+
+func userDisable(fs *flag.FlagSet, args []string) error {
+	a, err := open(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx := context.Background()
+	user, err := a.userByEmail(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err = a.services.UserService.Disable(ctx, user.ID); err != nil {
+		return err
+	}
+	a.audit(ctx, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditUserDisable,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	// A disabled account must not keep any live sessions around.
+	if err = a.revokeSessions(ctx, user); err != nil {
+		return err
+	}
+	fmt.Printf("Disabled %s\n", user.Email)
+
+	return nil
+}
+
+// revokeSessions signs the user out everywhere.
+func (a *app) revokeSessions(ctx context.Context, user *models.User) error {
+	if err := a.services.SessionService.DeleteByUserID(ctx, user.ID); err != nil {
+		return err
+	}
+	a.audit(ctx, models.AuditEvent{
+		OwnerID: &user.ID,
+		Action:  models.AuditSessionRevoke,
+		Target:  "user:" + strconv.Itoa(user.ID),
+	})
+
+	return nil
+}
@@ -7,17 +7,21 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/azdanov/imago/context"
+	"github.com/azdanov/imago/logging"
 	"github.com/azdanov/imago/models"
+	"github.com/azdanov/imago/tracing"
 	"github.com/gorilla/csrf"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type Template struct {
 	htmlTmpl *template.Template
+	// name is the page template, used to name the render span.
+	name string
 }
 
 const baseTemplate = "layouts/base.tmpl.html"
@@ -25,6 +29,7 @@ const baseTemplate = "layouts/base.tmpl.html"
 var baseTemplates = []string{
 	baseTemplate,
 	"layouts/notifications.tmpl.html",
+	"layouts/live.tmpl.html",
 }
 
 func Parse(fs fs.FS, pattern ...string) (*Template, error) {
@@ -37,7 +42,7 @@ func Parse(fs fs.FS, pattern ...string) (*Template, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse: %w", err)
 	}
-	return &Template{htmlTmpl: t}, nil
+	return &Template{htmlTmpl: t, name: pattern[0]}, nil
 }
 
 func Must(t *Template, err error) *Template {
@@ -48,9 +53,14 @@ func Must(t *Template, err error) *Template {
 }
 
 func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any) {
+	_, span := tracing.Tracer.Start(r.Context(), "render "+t.name)
+	defer span.End()
+
 	tpl, err := t.htmlTmpl.Clone()
 	if err != nil {
-		log.Printf("clone template: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "clone template")
+		logging.FromContext(r.Context()).Error("clone template", "error", err)
 		http.Error(w, "There was an error processing your request", http.StatusInternalServerError)
 		return
 	}
@@ -69,6 +79,9 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any) {
 			}
 			return models.SortNotifications(notifications)
 		},
+		"unreadNotifications": func() int {
+			return context.UnreadNotifications(r.Context())
+		},
 	})
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -76,13 +89,15 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any) {
 	var buf bytes.Buffer
 	err = tpl.ExecuteTemplate(&buf, "base", data)
 	if err != nil {
-		log.Printf("execute template: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "execute template")
+		logging.FromContext(r.Context()).Error("execute template", "error", err)
 		http.Error(w, "There was an error processing your request", http.StatusInternalServerError)
 		return
 	}
 	_, err = io.Copy(w, &buf)
 	if err != nil {
-		log.Printf("copy template: %v", err)
+		logging.FromContext(r.Context()).Error("copy template", "error", err)
 		http.Error(w, "There was an error processing your request", http.StatusInternalServerError)
 		return
 	}
@@ -101,4 +116,7 @@ var funcMap = template.FuncMap{
 	"notifications": func() ([]models.Notification, error) {
 		return nil, errors.New("notifications: called in template without a request")
 	},
+	"unreadNotifications": func() (int, error) {
+		return 0, errors.New("unreadNotifications: called in template without a request")
+	},
 }